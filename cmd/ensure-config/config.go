@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"strconv"
+
+	"github.com/dottedmag/gozo/internal/noderef"
 )
 
 type config struct {
@@ -18,87 +20,144 @@ type configDeviceType struct {
 }
 
 type configDeviceTypeParam struct {
-	// FIXME (dottedmag): Support "sub-parameters"
 	ID          int
+	Bitmask     uint32 // of a sub-parameter
+	Size        int    // in bytes, if zwave-js does not know the parameter
+	Signed      bool
 	Description string
-	Default     *uint   `toml:"default"`
+	Min         *int
+	Max         *int
+	Allowed     []int   // the only accepted values, if set
+	Default     *int    `toml:"default"`
 	DefaultHex  *string `toml:"default_hex"`
 }
 
 type configNode struct {
 	ID          int
+	Name        string // "location/name" or just name, instead of ID
 	DeviceType  string `toml:"device_type"`
 	Description string
 	Params      []configNodeParam
 }
 
 type configNodeParam struct {
-	ID    int
-	Value *int
+	ID      int
+	Bitmask uint32
+	Value   *int
 }
 
-func parseConfig(c config) (map[int]node, error) {
-	out := map[int]node{}
+// parseConfig returns nodes keyed by node reference, see gozo.Conn.ResolveNode
+func parseConfig(c config) (map[string]node, error) {
+	out := map[string]node{}
 
 	dts := map[string]deviceType{}
 	for _, dt := range c.DeviceTypes {
 		if _, ok := dts[dt.Name]; ok {
 			return nil, fmt.Errorf("device type %s: duplicate", dt.Name)
 		}
-		dts[dt.Name] = deviceType{paramsDefaultValues: map[int]*uint{}, paramsDescriptions: map[int]string{}}
+		dts[dt.Name] = deviceType{params: map[paramKey]param{}, paramsDefaultValues: map[paramKey]int64{}}
+
+		for _, cp := range dt.Params {
+			k := paramKey{id: cp.ID, bitmask: cp.Bitmask}
+			p := param{description: cp.Description, size: cp.Size, signed: cp.Signed, min: cp.Min, max: cp.Max, allowed: cp.Allowed}
+			if err := checkParam(dts[dt.Name].params, k, p); err != nil {
+				return nil, fmt.Errorf("device type %s: parameter %v: %w", dt.Name, k, err)
+			}
 
-		for _, param := range dt.Params {
 			switch {
-			case param.Default != nil && param.DefaultHex != nil:
-				return nil, fmt.Errorf("device type %s: parameter %d has both default and defaultHex values", dt.Name, param.ID)
-			case param.Default != nil:
-				dts[dt.Name].paramsDefaultValues[param.ID] = param.Default
-			case param.DefaultHex != nil:
-				u, err := strconv.ParseUint(*param.DefaultHex, 16, 32)
+			case cp.Default != nil && cp.DefaultHex != nil:
+				return nil, fmt.Errorf("device type %s: parameter %v has both default and defaultHex values", dt.Name, k)
+			case cp.Default != nil:
+				if err := p.checkValue(k, int64(*cp.Default)); err != nil {
+					return nil, fmt.Errorf("device type %s: parameter %v: default: %w", dt.Name, k, err)
+				}
+				dts[dt.Name].paramsDefaultValues[k] = int64(*cp.Default)
+			case cp.DefaultHex != nil:
+				u, err := strconv.ParseUint(*cp.DefaultHex, 16, 32)
 				if err != nil {
-					return nil, fmt.Errorf("device type %s: parameter %d: defaultHex value %s is not a valid hex number", dt.Name, param.ID, *param.DefaultHex)
+					return nil, fmt.Errorf("device type %s: parameter %v: defaultHex value %s is not a valid hex number", dt.Name, k, *cp.DefaultHex)
 				}
-				v := uint(u)
-				dts[dt.Name].paramsDefaultValues[param.ID] = &v
+				if err := p.checkValue(k, int64(u)); err != nil {
+					return nil, fmt.Errorf("device type %s: parameter %v: defaultHex: %w", dt.Name, k, err)
+				}
+				dts[dt.Name].paramsDefaultValues[k] = int64(u)
 			}
-			dts[dt.Name].paramsDescriptions[param.ID] = param.Description
+			dts[dt.Name].params[k] = p
 		}
 
 	}
 
 	for _, cn := range c.Nodes {
-		if _, ok := out[cn.ID]; ok {
-			return nil, fmt.Errorf("node %d is present multiple times in config", cn.ID)
+		ref, err := noderef.Ref(cn.ID, cn.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := out[ref]; ok {
+			return nil, fmt.Errorf("node %s is present multiple times in config", ref)
 		}
 		dt, ok := dts[cn.DeviceType]
 		if !ok {
-			return nil, fmt.Errorf("node %d: device type %s is not defined", cn.ID, cn.DeviceType)
+			return nil, fmt.Errorf("node %s: device type %s is not defined", ref, cn.DeviceType)
 		}
 
-		params := map[int]param{}
+		params := map[paramKey]param{}
 		for _, cp := range cn.Params {
-			if _, ok := params[cp.ID]; ok {
-				return nil, fmt.Errorf("parameter %d for node %d is present multiple times in config", cp.ID, cn.ID)
+			k := paramKey{id: cp.ID, bitmask: cp.Bitmask}
+			if _, ok := params[k]; ok {
+				return nil, fmt.Errorf("parameter %v for node %s is present multiple times in config", k, ref)
 			}
-			if dt.paramsDescriptions[cp.ID] == "" {
-				return nil, fmt.Errorf("parameter %d for node %d is not defined in device type %s", cp.ID, cn.ID, cn.DeviceType)
+			p, ok := dt.params[k]
+			if !ok {
+				return nil, fmt.Errorf("parameter %v for node %s is not defined in device type %s", k, ref, cn.DeviceType)
 			}
 			if cp.Value == nil {
-				return nil, fmt.Errorf("parameter %d for node %d has no value in config", cp.ID, cn.ID)
+				return nil, fmt.Errorf("parameter %v for node %s has no value in config", k, ref)
+			}
+			if err := p.checkValue(k, int64(*cp.Value)); err != nil {
+				return nil, fmt.Errorf("parameter %v for node %s: %w", k, ref, err)
 			}
 
-			params[cp.ID] = param{description: dt.paramsDescriptions[cp.ID], value: uint(*cp.Value)}
+			p.value = int64(*cp.Value)
+			params[k] = p
 		}
 
-		for id, v := range dt.paramsDefaultValues {
-			if params[id].description != "" {
+		for k, v := range dt.paramsDefaultValues {
+			if _, ok := params[k]; ok {
 				continue
 			}
-			params[id] = param{description: dt.paramsDescriptions[id], value: *v}
+			p := dt.params[k]
+			p.value = v
+			params[k] = p
 		}
 
-		out[cn.ID] = node{description: cn.Description, params: params}
+		out[ref] = node{description: cn.Description, params: params}
 	}
 
 	return out, nil
 }
+
+// checkParam checks the parameter against itself and the other parameters of
+// the device type
+func checkParam(params map[paramKey]param, k paramKey, p param) error {
+	switch {
+	case p.size != 0 && p.size != 1 && p.size != 2 && p.size != 4:
+		return fmt.Errorf("size %d is not 1, 2 or 4", p.size)
+	case p.size != 0 && k.bitmask&^sizeMask(p.size) != 0:
+		return fmt.Errorf("bitmask does not fit into %d bytes", p.size)
+	}
+
+	for other, op := range params {
+		if other.id != k.id {
+			continue
+		}
+		switch {
+		case other == k:
+			return fmt.Errorf("duplicate")
+		case other.bitmask == 0 || k.bitmask == 0 || other.bitmask&k.bitmask != 0:
+			return fmt.Errorf("overlaps with parameter %v", other)
+		case op.size != p.size:
+			return fmt.Errorf("size differs from the one of parameter %v", other)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/internal/noderef"
+)
+
+// Statuses of parameters in the diff
+const (
+	statusMatch      = "match"
+	statusDrift      = "drift"
+	statusUnreadable = "unreadable"
+	statusDead       = "dead"
+)
+
+// diffMain is "diff" subcommand: it reports parameters that differ from the
+// config without changing them. Its exit status is chosen by diffStatus.
+func diffMain(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: ensure-config diff <config-file>\n")
+		os.Exit(2)
+	}
+	path := args[0]
+
+	config, nodes, err := loadConfig(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config file %s: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	c, err := gozo.NewConn(config.ZWaveJSAPIEndpoint, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to zwave-js API endpoint %s: %v\n", config.ZWaveJSAPIEndpoint, err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	resolved, err := noderef.Resolve(c, nodes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resolve nodes: %v\n", err)
+		os.Exit(1)
+	}
+
+	drift, unchecked := diffNodes(context.Background(), os.Stdout, c, resolved)
+	os.Exit(diffStatus(drift, unchecked))
+}
+
+// diffStatus is the exit status of diff: 1 if any parameter has drifted, even
+// if others could not be checked, 3 if none has drifted but some could not be
+// checked, as drift cannot be ruled out then, and 0 otherwise
+func diffStatus(drift, unchecked bool) int {
+	switch {
+	case drift:
+		return 1
+	case unchecked:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// diffNodes writes the table of current and desired values of parameters of
+// the nodes, and reports whether any have drifted, and whether any could not
+// be checked as unreadable or belonging to dead nodes
+func diffNodes(ctx context.Context, w io.Writer, c *gozo.Conn, nodes map[int]node) (drift, unchecked bool) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "NODE\tPARAM\tDESCRIPTION\tCURRENT\tDESIRED\tSTATUS\n")
+
+	for _, id := range slices.Sorted(maps.Keys(nodes)) {
+		node := nodes[id]
+
+		var values map[paramKey]paramValue
+		var dead bool
+		var stateErr error
+		switch state, err := c.NodeGetState(ctx, id); {
+		case err != nil:
+			stateErr = err
+		case state.Status == gozo.NodeStatusDead:
+			dead = true
+		default:
+			// Sleeping nodes are not woken up, their values come from the
+			// cache
+			values, _ = readParams(ctx, c, id, node, state.Status == gozo.NodeStatusAsleep)
+		}
+
+		nodeName := fmt.Sprint(id)
+		if node.description != "" {
+			nodeName += " (" + node.description + ")"
+		}
+		for _, k := range slices.SortedFunc(maps.Keys(node.params), compareParamKeys) {
+			param := node.params[k]
+			v, ok := values[k]
+
+			current := "-"
+			var status string
+			switch {
+			case dead:
+				status = statusDead
+				unchecked = true
+			case stateErr != nil:
+				status = statusUnreadable + ": " + stateErr.Error()
+				unchecked = true
+			case !ok || v.state != valueKnown:
+				status = statusUnreadable
+				unchecked = true
+			case v.value == param.value:
+				current = v.String()
+				status = statusMatch
+			default:
+				current = v.String()
+				status = statusDrift
+				drift = true
+			}
+			fmt.Fprintf(tw, "%s\t%v\t%s\t%s\t%d\t%s\n", nodeName, k, param.description, current, param.value, status)
+		}
+	}
+
+	tw.Flush()
+	return drift, unchecked
+}
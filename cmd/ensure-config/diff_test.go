@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/gozotest"
+	"github.com/dottedmag/gozo/internal/noderef"
+)
+
+func TestDiffNodes(t *testing.T) {
+	ptr := func(v int) *int { return &v }
+	nodes, err := parseConfig(config{
+		DeviceTypes: []configDeviceType{
+			{Name: "zw111", Params: []configDeviceTypeParam{
+				{ID: 120, Description: "switch 1 mode", Default: ptr(3)},
+				{ID: 121, Description: "switch 2 mode", Default: ptr(3)},
+			}},
+			{Name: "sensor", Params: []configDeviceTypeParam{
+				{ID: 1, Description: "interval", Default: ptr(60)},
+				{ID: 2, Bitmask: 0x0f, Size: 1, Description: "led", Default: ptr(1)},
+			}},
+		},
+		Nodes: []configNode{
+			{ID: 2, DeviceType: "zw111", Description: "dimmer"},
+			{ID: 3, DeviceType: "zw111"},
+			{ID: 4, DeviceType: "sensor", Params: []configNodeParam{{ID: 1, Value: ptr(30)}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := gozotest.NewServer(t,
+		gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 3, Status: gozo.NodeStatusDead},
+		gozo.NodeState{NodeID: 4, Status: gozo.NodeStatusAsleep})
+	srv.SetValue(2, paramKey{id: 120}.valueID(), 3)
+	srv.SetValue(2, paramKey{id: 121}.valueID(), 1)
+	srv.SetValue(4, paramKey{id: 1}.valueID(), 30)
+
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	resolved, err := noderef.Resolve(c, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	drift, unchecked := diffNodes(context.Background(), &out, c, resolved)
+	if !drift || !unchecked {
+		t.Errorf("diffNodes reports drift %v, unchecked %v", drift, unchecked)
+	}
+	// Drift is confirmed even though the dead node could not be checked
+	if status := diffStatus(drift, unchecked); status != 1 {
+		t.Errorf("diff of drifting and dead nodes exits with %d, want 1", status)
+	}
+
+	expected := `NODE        PARAM   DESCRIPTION    CURRENT  DESIRED  STATUS
+2 (dimmer)  120     switch 1 mode  3        3        match
+2 (dimmer)  121     switch 2 mode  1        3        drift
+3           120     switch 1 mode  -        3        dead
+3           121     switch 2 mode  -        3        dead
+4           1       interval       30       30       match
+4           2[0xf]  led            -        1        unreadable
+`
+	if out.String() != expected {
+		t.Errorf("diff is\n%s\nwant\n%s", out.String(), expected)
+	}
+
+	for _, req := range srv.Requests() {
+		if req.Command == "node.set_value" || req.Command == "endpoint.invoke_cc_api" {
+			t.Errorf("diff has sent %s %v", req.Command, req.Params)
+		}
+	}
+
+	// Once fixed, there is no drift
+	srv.SetValue(2, paramKey{id: 121}.valueID(), 3)
+	out.Reset()
+	drift, unchecked = diffNodes(context.Background(), &out, c, resolved)
+	if drift {
+		t.Errorf("diffNodes reports drift after fix:\n%s", out.String())
+	}
+	// The dead node still could not be checked
+	if status := diffStatus(drift, unchecked); status != 3 {
+		t.Errorf("diff of fixed and dead nodes exits with %d, want 3", status)
+	}
+
+	// Failures to query nodes are reported
+	out.Reset()
+	srv.Fail("node.get_state", &gozo.ServerError{ErrorCode: gozo.ErrorCodeUnknownError, Message: "boom"})
+	if drift, unchecked := diffNodes(context.Background(), &out, c, map[int]node{2: resolved[2]}); drift || !unchecked {
+		t.Errorf("diffNodes of unqueried node reports drift %v, unchecked %v", drift, unchecked)
+	}
+	expected = `NODE        PARAM  DESCRIPTION    CURRENT  DESIRED  STATUS
+2 (dimmer)  120    switch 1 mode  -        3        unreadable: node.get_state failed: unknown_error: boom
+2 (dimmer)  121    switch 2 mode  -        3        unreadable: node.get_state failed: unknown_error: boom
+`
+	if out.String() != expected {
+		t.Errorf("diff is\n%s\nwant\n%s", out.String(), expected)
+	}
+}
@@ -1,173 +1,369 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"maps"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/dottedmag/gozo"
-	"github.com/pelletier/go-toml/v2"
+	"github.com/dottedmag/gozo/internal/configfile"
+	"github.com/dottedmag/gozo/internal/health"
+	"github.com/dottedmag/gozo/internal/noderef"
 )
 
-type deviceType struct {
-	paramsDescriptions  map[int]string
-	paramsDefaultValues map[int]*uint
-}
+const (
+	// checkInterval is the interval of checking configuration of all nodes
+	checkInterval = 5 * time.Minute
 
-type param struct {
-	description string
-	value       uint
+	// betweenNodes is the delay after changing a node before handling the
+	// next one, to avoid hogging bandwidth
+	betweenNodes = 10 * time.Second
+)
+
+type deviceType struct {
+	params              map[paramKey]param // without values
+	paramsDefaultValues map[paramKey]int64
 }
 
 type node struct {
 	id          int
 	description string
-	params      map[int]param
+	params      map[paramKey]param
 }
 
 func main() {
 	log.SetFlags(log.LUTC)
 
+	if len(os.Args) >= 2 && os.Args[1] == "import-device-type" {
+		importMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "diff" {
+		diffMain(os.Args[2:])
+		return
+	}
+
 	if len(os.Args) != 2 {
 		log.Printf("Usage: ensure-config <config-file>")
+		log.Printf("       ensure-config diff <config-file>")
+		log.Printf("       ensure-config import-device-type --endpoint <zwave-js-api-endpoint> <node>")
 		os.Exit(2)
 	}
 
-	fh, err := os.Open(os.Args[1])
+	path := os.Args[1]
+	changes := configfile.Watch(path, 5*time.Second)
+
+	config, nodes, err := loadConfig(path)
 	if err != nil {
-		log.Printf("FATAL: Failed to open config file %s: %v", os.Args[1], err)
+		log.Printf("FATAL: Failed to load config file %s: %v", path, err)
 		os.Exit(1)
 	}
 
-	var config config
-	dec := toml.NewDecoder(fh)
-	dec.DisallowUnknownFields()
-	if err := dec.Decode(&config); err != nil {
-		log.Printf("FATAL: Failed to parse config file %s: %v", os.Args[1], err)
-		os.Exit(1)
-	}
+	// Node configuration might have changed while zwave-js API endpoint was
+	// unreachable, so recheck it right away after reconnection
+	reconnected := make(chan struct{}, 1)
 
-	nodes, err := parseConfig(config)
+	c, err := gozo.NewConn(config.ZWaveJSAPIEndpoint, func(m map[string]interface{}) {},
+		gozo.WithReconnectHandler(func() {
+			log.Printf("INFO: Reconnected to zwave-js API endpoint %s", config.ZWaveJSAPIEndpoint)
+			select {
+			case reconnected <- struct{}{}:
+			default:
+			}
+		}))
 	if err != nil {
-		log.Printf("FATAL: Failed to parse config file %s: %v", os.Args[1], err)
+		log.Printf("FATAL: Failed to connect to zwave-js API endpoint %s: %v", config.ZWaveJSAPIEndpoint, err)
 		os.Exit(1)
 	}
 
-	c, err := gozo.NewConn(config.ZWaveJSAPIEndpoint, func(m map[string]interface{}) {})
+	// Subscribe before resolving node names, so that no additions are missed
+	added, _ := c.Subscribe(gozo.EventFilter{
+		Source: gozo.SourceController,
+		Events: []string{gozo.EventNodeAdded},
+	})
+	status, _ := c.Subscribe(gozo.EventFilter{
+		Source: gozo.SourceNode,
+		Events: []string{gozo.EventAlive, gozo.EventWakeUp, gozo.EventDead},
+	})
+
+	resolved, err := noderef.Resolve(c, nodes)
 	if err != nil {
-		// TODO (dottedmag): Handle zwave-js API endpoint reconnections
-		log.Printf("FATAL: Failed to connect to zwave-js API endpoint %s: %v", config.ZWaveJSAPIEndpoint, err)
+		log.Printf("FATAL: Failed to resolve nodes: %v", err)
+		os.Exit(1)
+	}
+	if err := checkNodes(c, resolved); err != nil {
+		log.Printf("FATAL: Config does not match zwave-js: %v", err)
 		os.Exit(1)
 	}
 
-	for id, node := range nodes {
+	for id, node := range resolved {
 		log.Printf("INFO: Servicing node %d (%s)", id, node.description)
 	}
 
-	for {
-		var anyFailed, anyDead bool
+	ctx := context.Background()
+	h := health.New(health.DefaultMin, health.DefaultMax)
+	held := map[int]bool{} // nodes with changes held until they wake up
+	// TODO (dottedmag): Increase precision of scheduling
+	var nextCheck time.Time // of all nodes, in between only failed ones are retried
 
-		for id, node := range nodes {
-			var anyChange bool
-
-			log.Printf("INFO: Handling node %d", id)
+	for {
+		all := !time.Now().Before(nextCheck)
+		if all {
+			nextCheck = time.Now().Add(checkInterval)
+		}
+		ensureNodes(ctx, c, resolved, h, held, all)
 
-			resp, err := c.Call("node.get_state", map[string]any{
-				"nodeId": id,
-			})
+		delay := time.Until(nextCheck)
+		if retry := h.Next(); !retry.IsZero() && time.Until(retry) < delay {
+			delay = time.Until(retry)
+		}
 
+		select {
+		case <-time.After(delay):
+		case <-reconnected:
+			nextCheck = time.Time{}
+		case <-added:
+			nextCheck = time.Time{}
+		case ev, ok := <-status:
+			if !ok {
+				status = nil // connection is closed
+				continue
+			}
+			id := ev.Header().NodeID
+			n, ok := resolved[id]
+			switch {
+			case !ok:
+			case ev.Header().Event == gozo.EventDead:
+				h.Park(id, time.Now())
+				log.Printf("INFO: Node %d (%s) is dead, parked until alive or for %v", id, n.description, health.DefaultMax)
+			case h.Alive(id) || held[id]:
+				// Right away, before the node goes back to sleep
+				log.Printf("INFO: Node %d (%s) is %s, retrying", id, n.description, ev.Header().Event)
+				ensureNodes(ctx, c, map[int]node{id: n}, h, held, true)
+			}
+		case <-changes:
+			newConfig, newNodes, err := loadConfig(path)
 			if err != nil {
-				log.Printf("ERR: failed to query state of node %d (%s): %v", id, node.description, err)
-				anyFailed = true
+				log.Printf("ERR: Failed to load changed config file %s, keeping the previous one: %v", path, err)
 				continue
 			}
-
-			if resp["success"] == nil || !resp["success"].(bool) {
-				log.Printf("ERR: failed to query state of node %d (%s): %v", id, node.description, resp)
-				anyFailed = true
+			if newConfig.ZWaveJSAPIEndpoint != config.ZWaveJSAPIEndpoint {
+				log.Printf("ERR: Change of zwave-js API endpoint needs a restart, staying connected to %s", config.ZWaveJSAPIEndpoint)
+			}
+			r, err := noderef.Resolve(c, newNodes)
+			if err != nil {
+				log.Printf("ERR: Failed to resolve nodes of changed config file %s, keeping the previous one: %v", path, err)
 				continue
 			}
-
-			if resp["result"].(map[string]any)["state"].(map[string]any)["status"].(float64) == 3 {
-				log.Printf("INFO: Node %d (%s) is dead", id, node.description)
-				anyDead = true
+			if err := checkNodes(c, r); err != nil {
+				log.Printf("ERR: Changed config file %s does not match zwave-js, keeping the previous one: %v", path, err)
 				continue
 			}
-
-			for n, param := range node.params {
-				resp, err := c.Call("node.get_value", map[string]any{
-					"nodeId": id,
-					"valueId": map[string]any{
-						"commandClass": 0x70, // Configuration CC
-						"property":     n,
-					},
-				})
-
-				if err != nil {
-					log.Printf("ERR: Failed to obtain current value %d (%s) %d (%s): %v", id, node.description, n, param.description, err)
-					anyFailed = true
-					continue
-				}
-
-				if resp["success"] == nil || !resp["success"].(bool) {
-					log.Printf("ERR: Failed to obtain current value %d (%s) %d (%s): %#v", id, node.description, n, param.description, resp)
-					anyFailed = true
-					continue
-				}
-
-				anyValue := resp["result"].(map[string]any)["value"]
-				var vf bool
-				var value uint
-				if anyValue == nil {
-					log.Printf("ERR: Empty current value %d (%s) %d (%s): %#v", id, node.description, n, param.description, resp)
-				} else {
-					vf = true
-					value = uint(anyValue.(float64))
+			addedRefs, removedRefs, changedRefs := configfile.Diff(nodes, newNodes)
+			log.Printf("INFO: Reloaded config: added nodes %v, removed nodes %v, changed nodes %v", addedRefs, removedRefs, changedRefs)
+			nodes = newNodes
+			for id, node := range resolved {
+				if _, ok := r[id]; !ok {
+					log.Printf("INFO: No longer servicing node %d (%s)", id, node.description)
+					h.Forget(id)
+					delete(held, id)
 				}
-
-				if !vf || value != param.value {
-					anyChange = true
-
-					resp, err := c.Call("node.set_value", map[string]any{
-						"nodeId": id,
-						"valueId": map[string]any{
-							"commandClass": 0x70, // Configuration CC
-							"property":     n,
-						},
-						"value": param.value,
-					})
-
-					if err != nil {
-						log.Printf("ERR: Failed to set value %d (%s) %d (%s) %d->%d: %v", id, node.description, n, param.description, value, param.value, err)
-						anyFailed = true
-						continue
-					}
-
-					// TODO (dottedmag): Recongnize "node is offline", and use different scheduling algorithm
-					// (offline nodes are likely to stay offline for a while, as they are probably just unplugged)
-					if resp["success"] == nil || !resp["success"].(bool) {
-						log.Printf("ERR: Failed to set value %d (%s) %d (%s) %d->%d: %#v", id, node.description, n, param.description, value, param.value, resp)
-						anyFailed = true
-						continue
-					}
-
-					log.Printf("INFO: Set value %d (%s) %d (%s) %v->%v", id, node.description, n, param.description, value, param.value)
+			}
+			for id, node := range r {
+				if _, ok := resolved[id]; !ok {
+					log.Printf("INFO: Servicing node %d (%s)", id, node.description)
 				}
 			}
+			resolved = r
+			nextCheck = time.Time{}
+			continue
+		case <-c.Done():
+			log.Printf("FATAL: Lost connection to zwave-js API endpoint %s: %v", config.ZWaveJSAPIEndpoint, c.Err())
+			os.Exit(1)
+		}
 
-			if anyChange {
-				time.Sleep(10 * time.Second) // Some delay between nodes to avoid hogging bandwith
+		// Named nodes might have been re-included under a different ID
+		r, err := noderef.Resolve(c, nodes)
+		if err != nil {
+			log.Printf("ERR: Failed to resolve nodes, keeping the previous ones: %v", err)
+			continue
+		}
+		for id := range resolved {
+			if _, ok := r[id]; !ok {
+				h.Forget(id)
+				delete(held, id)
 			}
 		}
+		resolved = r
+	}
+}
 
-		if anyFailed {
-			log.Printf("INFO: Handling of some nodes was unsuccessful, retrying in 10 seconds")
-			time.Sleep(10 * time.Second)
-		} else if anyDead {
-			time.Sleep(5 * time.Minute)
+// loadConfig loads and validates the config file
+func loadConfig(path string) (config, map[string]node, error) {
+	var c config
+	if err := configfile.Load(path, &c); err != nil {
+		return c, nil, err
+	}
+	nodes, err := parseConfig(c)
+	return c, nodes, err
+}
+
+// checkNodes checks the configured values against the limits known to
+// zwave-js, see checkMetadata
+func checkNodes(c *gozo.Conn, nodes map[int]node) error {
+	for _, id := range slices.Sorted(maps.Keys(nodes)) {
+		if err := checkMetadata(c, id, nodes[id]); err != nil {
+			return fmt.Errorf("node %d (%s): %w", id, nodes[id].description, err)
+		}
+	}
+	return nil
+}
+
+// ensureNodes handles the nodes that are due according to their health: all of
+// them, or only the ones being retried. Nodes with changes held until they
+// wake up are recorded in held.
+func ensureNodes(ctx context.Context, c *gozo.Conn, nodes map[int]node, h *health.Tracker, held map[int]bool, all bool) {
+	var pause bool
+	for id, node := range nodes {
+		if !h.Due(id, time.Now()) || !all && h.Healthy(id) {
+			continue
+		}
+		if pause {
+			time.Sleep(betweenNodes)
+		}
+
+		changed, failed, dead, asleep := ensureNode(ctx, c, id, node)
+		pause = changed
+		held[id] = asleep
+		now := time.Now()
+		switch {
+		case dead:
+			h.Park(id, now)
+			log.Printf("INFO: Parked node %d (%s) until alive or for %v", id, node.description, health.DefaultMax)
+		case failed:
+			retry := h.Failed(id, now)
+			log.Printf("INFO: Handling of node %d (%s) was unsuccessful, retrying in %v", id, node.description, retry.Sub(now))
+		default:
+			h.OK(id)
+		}
+	}
+}
+
+// change is a parameter value to be set
+type change struct {
+	key         paramKey
+	description string
+	from        any // current value
+	to          any
+	size        int // of raw parameters, set whole
+}
+
+// ensureNode brings configuration of the node in line with the config.
+//
+// Sleeping nodes do not get commands until they wake up, so changes to them
+// are held (asleep is set), and are sent in one batch once the node is handled
+// again during its wake-up.
+func ensureNode(ctx context.Context, c *gozo.Conn, id int, node node) (changed, failed, dead, asleep bool) {
+	log.Printf("INFO: Handling node %d", id)
+
+	state, err := c.NodeGetState(ctx, id)
+	if err != nil {
+		log.Printf("ERR: failed to query state of node %d (%s): %v", id, node.description, err)
+		return false, true, false, false
+	}
+
+	if state.Status == gozo.NodeStatusDead {
+		log.Printf("INFO: Node %d (%s) is dead", id, node.description)
+		return false, false, true, false
+	}
+	asleep = state.Status == gozo.NodeStatusAsleep
+
+	// zwave-js might have learnt the limits after the config was checked,
+	// e.g. by interviewing the node
+	if err := checkMetadata(c, id, node); err != nil {
+		log.Printf("ERR: Config of node %d (%s) does not match zwave-js: %v", id, node.description, err)
+		return false, true, false, false
+	}
+
+	values, wholes := readParams(ctx, c, id, node, asleep)
+
+	var changes []change
+	var unread int
+	rawChanges := map[int]*change{} // with sub-parameters set so far
+	for _, k := range slices.SortedFunc(maps.Keys(node.params), compareParamKeys) {
+		param := node.params[k]
+		v := values[k]
+
+		switch {
+		case v.state == valueFailed:
+			failed = true
+			continue
+		case v.state == valueUnread:
+			unread++
+			continue
+		case v.state == valueKnown && v.value == param.value:
+			continue
+		case !param.raw():
+			changes = append(changes, change{key: k, description: param.description, from: v, to: param.value})
+			continue
+		}
+
+		// Only the masked bits are changed, the rest of the value is kept
+		ch := rawChanges[k.id]
+		if ch == nil {
+			ch = &change{key: paramKey{id: k.id}, from: wholes[k.id], to: wholes[k.id], size: param.size}
+			rawChanges[k.id] = ch
+		}
+		ch.to = param.put(k, ch.to.(uint32))
+		if ch.description != "" {
+			ch.description += ", "
+		}
+		ch.description += param.description
+	}
+	for _, n := range slices.Sorted(maps.Keys(rawChanges)) {
+		if ch := rawChanges[n]; ch.from != ch.to {
+			changes = append(changes, *ch)
+		}
+	}
+
+	if asleep && unread != 0 {
+		log.Printf("INFO: Node %d (%s) is asleep, holding reads of %d parameters until it wakes up", id, node.description, unread)
+		return changed, failed, false, true
+	}
+
+	for i, ch := range changes {
+		// The node might fall asleep during the batch
+		if n, ok := c.Node(id); ok && n.Status == gozo.NodeStatusAsleep {
+			asleep = true
+		}
+		if asleep {
+			log.Printf("INFO: Node %d (%s) is asleep, holding %d changes until it wakes up", id, node.description, len(changes)-i)
+			return changed, failed, false, true
+		}
+
+		changed = true
+		var err error
+		if ch.size != 0 {
+			err = writeWhole(ctx, c, id, ch.key.id, ch.size, ch.to.(uint32))
 		} else {
-			// TODO (dottedmag): Increase precision of scheduling
-			time.Sleep(5 * time.Minute)
+			err = c.NodeSetValue(ctx, id, ch.key.valueID(), ch.to)
+		}
+		if gozo.IsNodeDead(err) {
+			log.Printf("INFO: Node %d (%s) is dead, failed to set value %v (%s)", id, node.description, ch.key, ch.description)
+			return changed, failed, true, false
+		}
+		if err != nil {
+			log.Printf("ERR: Failed to set value %d (%s) %v (%s) %v->%v: %v", id, node.description, ch.key, ch.description, ch.from, ch.to, err)
+			failed = true
+			continue
 		}
+
+		log.Printf("INFO: Set value %d (%s) %v (%s) %v->%v", id, node.description, ch.key, ch.description, ch.from, ch.to)
 	}
+
+	return changed, failed, false, false
 }
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/gozotest"
+	"github.com/dottedmag/gozo/internal/health"
+	"github.com/dottedmag/gozo/internal/noderef"
+)
+
+func TestEnsureNode(t *testing.T) {
+	def := 3
+	value := 2
+	nodes, err := parseConfig(config{
+		DeviceTypes: []configDeviceType{{
+			Name: "zw111",
+			Params: []configDeviceTypeParam{
+				{ID: 120, Description: "switch 1 mode", Default: &def},
+				{ID: 121, Description: "switch 2 mode", Default: &def},
+			},
+		}},
+		Nodes: []configNode{
+			{ID: 2, DeviceType: "zw111", Params: []configNodeParam{{ID: 120, Value: &value}}},
+			{ID: 3, DeviceType: "zw111"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := gozotest.NewServer(t,
+		gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 3, Status: gozo.NodeStatusDead})
+	srv.SetValue(2, paramKey{id: 120}.valueID(), 3)
+	srv.SetValue(2, paramKey{id: 121}.valueID(), 3)
+
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	changed, failed, dead, _ := ensureNode(ctx, c, 2, nodes["2"])
+	if !changed || failed || dead {
+		t.Errorf("first pass over node 2: changed=%v failed=%v dead=%v, want only changed", changed, failed, dead)
+	}
+	if v, _ := srv.Value(2, paramKey{id: 120}.valueID()); v != 2.0 {
+		t.Errorf("parameter 120 of node 2 is %#v, want 2", v)
+	}
+
+	srv.ClearRequests()
+	changed, failed, dead, _ = ensureNode(ctx, c, 2, nodes["2"])
+	if changed || failed || dead {
+		t.Errorf("second pass over node 2: changed=%v failed=%v dead=%v, want nothing", changed, failed, dead)
+	}
+	for _, req := range srv.Requests() {
+		if req.Command == "node.set_value" {
+			t.Errorf("second pass over node 2 has set a value: %v", req.Params)
+		}
+	}
+
+	changed, failed, dead, _ = ensureNode(ctx, c, 3, nodes["3"])
+	if changed || failed || !dead {
+		t.Errorf("pass over dead node 3: changed=%v failed=%v dead=%v, want only dead", changed, failed, dead)
+	}
+
+	srv.Fail("node.get_value", &gozo.ServerError{ErrorCode: gozo.ErrorCodeUnknownError, Message: "boom"})
+	changed, failed, dead, _ = ensureNode(ctx, c, 2, nodes["2"])
+	if changed || !failed || dead {
+		t.Errorf("pass over node 2 with failing reads: changed=%v failed=%v dead=%v, want only failed", changed, failed, dead)
+	}
+}
+
+func TestEnsureNodeMetadata(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		value int
+		md    gozo.ValueMetadata
+		err   string
+	}{
+		{value: 4, md: gozo.ValueMetadata{Min: f(0), Max: f(4)}},
+		{value: 5, md: gozo.ValueMetadata{Min: f(0), Max: f(4)}, err: "more than maximum"},
+		{value: 0, md: gozo.ValueMetadata{Min: f(1), Max: f(4)}, err: "less than minimum"},
+		{value: 2, md: gozo.ValueMetadata{States: map[string]string{"1": "toggle", "3": "momentary"}}, err: "not one of [1 3]"},
+		{value: 2, md: gozo.ValueMetadata{States: map[string]string{"1": "toggle"}, AllowManualEntry: true}},
+	}
+
+	for _, tt := range tests {
+		nodes, err := parseConfig(config{
+			DeviceTypes: []configDeviceType{{
+				Name:   "zw111",
+				Params: []configDeviceTypeParam{{ID: 120, Description: "switch 1 mode"}},
+			}},
+			Nodes: []configNode{{ID: 2, DeviceType: "zw111", Params: []configNodeParam{{ID: 120, Value: &tt.value}}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive})
+		srv.SetValue(2, paramKey{id: 120}.valueID(), 3)
+		srv.SetValueMetadata(2, paramKey{id: 120}.valueID(), tt.md)
+		c, err := gozo.NewConn(srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		resolved, err := noderef.Resolve(c, nodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkNodes(c, resolved)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("value %d: %v", tt.value, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("value %d: error %v, want %q", tt.value, err, tt.err)
+		}
+
+		// Values rejected by zwave-js are not set
+		_, failed, _, _ := ensureNode(context.Background(), c, 2, resolved[2])
+		set := slices.ContainsFunc(srv.Requests(), func(req gozotest.Request) bool { return req.Command == "node.set_value" })
+		if failed != (tt.err != "") || set == failed {
+			t.Errorf("value %d: failed=%v, set=%v, want error %q", tt.value, failed, set, tt.err)
+		}
+	}
+}
+
+func TestEnsureNodes(t *testing.T) {
+	def := 3
+	nodes, err := parseConfig(config{
+		DeviceTypes: []configDeviceType{{
+			Name:   "zw111",
+			Params: []configDeviceTypeParam{{ID: 120, Description: "switch 1 mode", Default: &def}},
+		}},
+		Nodes: []configNode{{ID: 2, DeviceType: "zw111"}, {ID: 3, DeviceType: "zw111"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := gozotest.NewServer(t,
+		gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 3, Status: gozo.NodeStatusDead})
+	srv.SetValue(2, paramKey{id: 120}.valueID(), 3)
+
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	resolved, err := noderef.Resolve(c, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handled := func() []int {
+		var ids []int
+		for _, req := range srv.Requests() {
+			if req.Command == "node.get_state" {
+				ids = append(ids, int(req.Params["nodeId"].(float64)))
+			}
+		}
+		srv.ClearRequests()
+		slices.Sort(ids)
+		return ids
+	}
+
+	h := health.New(time.Minute, time.Hour)
+	held := map[int]bool{}
+	ensureNodes(ctx, c, resolved, h, held, true)
+	if ids := handled(); !slices.Equal(ids, []int{2, 3}) || !h.Healthy(2) || !h.Dead(3) {
+		t.Errorf("full pass handled %v, node 3 dead=%v, want [2 3] and dead", ids, h.Dead(3))
+	}
+
+	// Retries leave healthy nodes alone, and parked ones until they are alive
+	ensureNodes(ctx, c, resolved, h, held, false)
+	if ids := handled(); len(ids) != 0 {
+		t.Errorf("retry pass handled %v, want nothing", ids)
+	}
+
+	srv.SetNodeStatus(3, gozo.NodeStatusAlive)
+	h.Alive(3)
+	ensureNodes(ctx, c, resolved, h, held, false)
+	if ids := handled(); !slices.Equal(ids, []int{3}) || !h.Healthy(3) {
+		t.Errorf("retry pass after node 3 is alive handled %v, want [3]", ids)
+	}
+	if v, _ := srv.Value(3, paramKey{id: 120}.valueID()); v != 3.0 {
+		t.Errorf("parameter 120 of node 3 is %#v, want 3", v)
+	}
+
+	// Failed nodes are retried once the backoff is over
+	srv.Fail("node.get_state", &gozo.ServerError{ErrorCode: gozo.ErrorCodeUnknownError, Message: "boom"})
+	ensureNodes(ctx, c, resolved, h, held, true)
+	handled()
+	if h.Healthy(2) || h.Due(2, time.Now()) || !h.Due(2, time.Now().Add(time.Minute)) {
+		t.Errorf("failed node 2 is not retried in a minute")
+	}
+}
+
+func TestEnsureSleepingNode(t *testing.T) {
+	def := 3
+	nodes, err := parseConfig(config{
+		DeviceTypes: []configDeviceType{{
+			Name: "zw111",
+			Params: []configDeviceTypeParam{
+				{ID: 120, Description: "switch 1 mode", Default: &def},
+				{ID: 121, Description: "switch 2 mode", Default: &def},
+			},
+		}},
+		Nodes: []configNode{{ID: 2, DeviceType: "zw111"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAsleep})
+	srv.SetValue(2, paramKey{id: 120}.valueID(), 1)
+	srv.SetValue(2, paramKey{id: 121}.valueID(), 1)
+
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	changed, failed, dead, asleep := ensureNode(ctx, c, 2, nodes["2"])
+	if changed || failed || dead || !asleep {
+		t.Errorf("pass over sleeping node: changed=%v failed=%v dead=%v asleep=%v, want only asleep", changed, failed, dead, asleep)
+	}
+	for _, req := range srv.Requests() {
+		if req.Command == "node.set_value" {
+			t.Errorf("pass over sleeping node has set a value: %v", req.Params)
+		}
+	}
+
+	srv.SetNodeStatus(2, gozo.NodeStatusAwake)
+	changed, failed, dead, asleep = ensureNode(ctx, c, 2, nodes["2"])
+	if !changed || failed || dead || asleep {
+		t.Errorf("pass over woken up node: changed=%v failed=%v dead=%v asleep=%v, want only changed", changed, failed, dead, asleep)
+	}
+	for _, n := range []int{120, 121} {
+		if v, _ := srv.Value(2, paramKey{id: n}.valueID()); v != 3.0 {
+			t.Errorf("parameter %d of node 2 is %#v, want 3", n, v)
+		}
+	}
+}
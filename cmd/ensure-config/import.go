@@ -0,0 +1,210 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/internal/cli"
+)
+
+// importMain is "import-device-type" subcommand: it prints a device type
+// with the parameters of the node, as described by zwave-js
+func importMain(args []string) {
+	fs := flag.NewFlagSet("ensure-config import-device-type", flag.ExitOnError)
+	endpoint := fs.String("endpoint", "", "zwave-js API endpoint, e.g. ws://localhost:3000")
+
+	positional, _ := cli.ParseFlags(fs, args) // exits on errors
+	if len(positional) != 1 || *endpoint == "" {
+		fmt.Fprintf(os.Stderr, "Usage: ensure-config import-device-type --endpoint <zwave-js-api-endpoint> <node>\n")
+		os.Exit(2)
+	}
+
+	c, err := gozo.NewConn(*endpoint, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to zwave-js API endpoint %s: %v\n", *endpoint, err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	if err := importDeviceType(os.Stdout, c, positional[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// configParamValue is a Configuration CC value of a node, with its
+// parameter
+type configParamValue struct {
+	key paramKey
+	gozo.Value
+}
+
+// importDeviceType writes the device type block of the node, built from
+// metadata of its Configuration CC values
+func importDeviceType(w io.Writer, c *gozo.Conn, ref string) error {
+	id, err := c.ResolveNode(ref)
+	if err != nil {
+		return err
+	}
+	n, ok := c.Node(id)
+	if !ok {
+		return fmt.Errorf("node %d not found", id)
+	}
+
+	var params []configParamValue
+	for _, v := range c.Values(id) {
+		if k, ok := paramKeyOf(v.ValueID); ok {
+			params = append(params, configParamValue{key: k, Value: v})
+		}
+	}
+	if len(params) == 0 {
+		return fmt.Errorf("node %d has no configuration parameters known to zwave-js, is it interviewed?", id)
+	}
+	slices.SortFunc(params, func(a, b configParamValue) int {
+		return compareParamKeys(a.key, b.key)
+	})
+
+	name := strings.ToLower(strings.ReplaceAll(n.Label, " ", "-"))
+	if name == "" {
+		name = "node-" + strconv.Itoa(id)
+	}
+	var description string
+	if n.DeviceConfig != nil {
+		description = strings.TrimSpace(n.DeviceConfig.Manufacturer + " " + n.DeviceConfig.Description)
+	}
+
+	fmt.Fprintf(w, "[[device_type]]\n")
+	fmt.Fprintf(w, "name = %s\n", tomlString(name))
+	fmt.Fprintf(w, "description = %s\n", tomlString(description))
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "# Imported from node %d. Parameters without default are left as they are,\n", id)
+	fmt.Fprintf(w, "# unless set for a node.\n")
+	fmt.Fprintf(w, "params = [\n")
+	for _, p := range params {
+		md := p.Metadata
+
+		var comment []string
+		if md.Default != nil {
+			comment = append(comment, fmt.Sprintf("Factory default %v.", md.Default))
+		}
+		if md.Description != "" {
+			comment = append(comment, md.Description)
+		}
+		if !md.Writeable {
+			comment = append(comment, "Read-only.")
+		}
+		if len(comment) != 0 {
+			// Descriptions may span lines, each of which needs to be
+			// commented
+			for line := range strings.SplitSeq(strings.Join(comment, " "), "\n") {
+				fmt.Fprintf(w, "       %s\n", strings.TrimRight("# "+strings.Map(commentRune, line), " "))
+			}
+		}
+
+		line := fmt.Sprintf("{id=%d", p.key.id)
+		if p.key.bitmask != 0 {
+			line += fmt.Sprintf(", bitmask=0x%x", p.key.bitmask)
+		}
+		if md.Min != nil && *md.Min < 0 {
+			line += ", signed=true"
+		}
+		line += fmt.Sprintf(", description=%s", tomlString(paramDescription(md)))
+		if md.Min != nil {
+			line += fmt.Sprintf(", min=%d", int(*md.Min))
+		}
+		if md.Max != nil {
+			line += fmt.Sprintf(", max=%d", int(*md.Max))
+		}
+		if states := stateValues(md); states != nil && !md.AllowManualEntry {
+			line += ", allowed=[" + strings.Join(states, ", ") + "]"
+		}
+		line += "},"
+
+		if !md.Writeable {
+			line = "# " + line
+		}
+		fmt.Fprintf(w, "       %s\n", line)
+	}
+	fmt.Fprintf(w, "]\n")
+	return nil
+}
+
+// tomlString quotes the string as TOML basic string. Unlike strconv.Quote, it
+// uses only the escapes TOML has, and replaces invalid UTF-8, which TOML does
+// not allow.
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range strings.ToValidUTF8(s, string(utf8.RuneError)) {
+		switch r {
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// commentRune drops the characters TOML does not allow in comments
+func commentRune(r rune) rune {
+	if r == utf8.RuneError || r < 0x20 && r != '\t' || r == 0x7f {
+		return -1
+	}
+	return r
+}
+
+// paramDescription is the label of the parameter with its unit and the names
+// of its values, as "switch mode (1 = toggle, 3 = momentary)"
+func paramDescription(md gozo.ValueMetadata) string {
+	out := md.Label
+	if md.Unit != "" {
+		out += ", " + md.Unit
+	}
+	if states := stateValues(md); states != nil {
+		var names []string
+		for _, s := range states {
+			names = append(names, s+" = "+md.States[s])
+		}
+		out += " (" + strings.Join(names, ", ") + ")"
+	}
+	return out
+}
+
+// stateValues returns the values that have names, in numeric order
+func stateValues(md gozo.ValueMetadata) []string {
+	if len(md.States) == 0 {
+		return nil
+	}
+	return slices.SortedFunc(maps.Keys(md.States), func(a, b string) int {
+		ai, _ := strconv.Atoi(a)
+		bi, _ := strconv.Atoi(b)
+		return cmp.Compare(ai, bi)
+	})
+}
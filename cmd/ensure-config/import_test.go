@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/gozotest"
+)
+
+func TestImportDeviceType(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+
+	srv := gozotest.NewServer(t, gozo.NodeState{
+		NodeID:       2,
+		Status:       gozo.NodeStatusAlive,
+		Label:        "ZW111",
+		DeviceConfig: &gozo.DeviceConfig{Manufacturer: "Aeotec", Description: "Nano Dimmer"},
+	})
+	srv.SetValueMetadata(2, paramKey{id: 120}.valueID(), gozo.ValueMetadata{
+		Label: "Switch 1 mode", Writeable: true, Min: f(0), Max: f(4), Default: 3.0,
+		States: map[string]string{"0": "disabled", "1": "toggle", "3": "momentary", "4": "auto"},
+	})
+	srv.SetValueMetadata(2, paramKey{id: 3, bitmask: 0xff00}.valueID(), gozo.ValueMetadata{
+		Label: "Brightness", Writeable: true, Unit: "%", Min: f(0), Max: f(99), Default: 50.0,
+		States: map[string]string{"0": "off"}, AllowManualEntry: true,
+	})
+	srv.SetValueMetadata(2, paramKey{id: 10}.valueID(), gozo.ValueMetadata{
+		Label: "Offset", Description: "Temperature offset.\r\n\nAdded to measured temperature.", Writeable: true, Min: f(-10), Max: f(10),
+	})
+	srv.SetValueMetadata(2, paramKey{id: 11}.valueID(), gozo.ValueMetadata{Label: "Firmware"})
+	srv.SetValueMetadata(2, gozo.ValueID{CommandClass: 0x26, Property: "currentValue"}, gozo.ValueMetadata{Label: "Level"})
+
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var out strings.Builder
+	if err := importDeviceType(&out, c, "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[[device_type]]
+name = "zw111"
+description = "Aeotec Nano Dimmer"
+
+# Imported from node 2. Parameters without default are left as they are,
+# unless set for a node.
+params = [
+       # Factory default 50.
+       {id=3, bitmask=0xff00, description="Brightness, % (0 = off)", min=0, max=99},
+       # Temperature offset.
+       #
+       # Added to measured temperature.
+       {id=10, signed=true, description="Offset", min=-10, max=10},
+       # Read-only.
+       # {id=11, description="Firmware"},
+       # Factory default 3.
+       {id=120, description="Switch 1 mode (0 = disabled, 1 = toggle, 3 = momentary, 4 = auto)", min=0, max=4, allowed=[0, 1, 3, 4]},
+]
+`
+	if out.String() != expected {
+		t.Fatalf("imported device type is\n%s\nwant\n%s", out.String(), expected)
+	}
+
+	// The block is ready to use, and limits values
+	for _, tt := range []struct {
+		params string
+		err    string
+	}{
+		{params: `{id=120, value=4}, {id=3, bitmask=0xff00, value=99}, {id=10, value=-10}`},
+		{params: `{id=120, value=2}`, err: "not one of allowed"},
+		{params: `{id=3, bitmask=0xff00, value=100}`, err: "more than maximum"},
+		{params: `{id=10, value=-11}`, err: "less than minimum"},
+	} {
+		path := filepath.Join(t.TempDir(), "config.toml")
+		config := out.String() + "\n[[node]]\nid = 2\ndevice_type = \"zw111\"\nparams = [" + tt.params + "]\n"
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err := loadConfig(path)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("config with params %s: %v", tt.params, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("config with params %s: error %v, want %q", tt.params, err, tt.err)
+		}
+	}
+}
+
+func TestImportQuoting(t *testing.T) {
+	const odd = "bell\a tab\t vt\v quote\" backslash\\ bad\xff del\x7f"
+
+	srv := gozotest.NewServer(t, gozo.NodeState{
+		NodeID:       2,
+		Status:       gozo.NodeStatusAlive,
+		Label:        "ZW111",
+		DeviceConfig: &gozo.DeviceConfig{Manufacturer: "Aeotec", Description: odd},
+	})
+	srv.SetValueMetadata(2, paramKey{id: 1}.valueID(), gozo.ValueMetadata{
+		Label: odd, Description: odd, Writeable: true,
+	})
+
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var out strings.Builder
+	if err := importDeviceType(&out, c, "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(out.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("imported device type does not load: %v\n%s", err, out.String())
+	}
+
+	want := strings.ToValidUTF8(odd, "�")
+	if len(cfg.DeviceTypes) != 1 || len(cfg.DeviceTypes[0].Params) != 1 {
+		t.Fatalf("loaded device types %+v", cfg.DeviceTypes)
+	}
+	if got := cfg.DeviceTypes[0].Description; got != "Aeotec "+want {
+		t.Errorf("description is %q, want %q", got, "Aeotec "+want)
+	}
+	if got := cfg.DeviceTypes[0].Params[0].Description; got != want {
+		t.Errorf("parameter description is %q, want %q", got, want)
+	}
+}
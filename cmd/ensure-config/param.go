@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"math/bits"
+	"slices"
+	"strconv"
+
+	"github.com/dottedmag/gozo"
+)
+
+const configurationCC = 0x70
+
+// paramKey identifies a parameter, or its sub-parameter if bitmask is set
+type paramKey struct {
+	id      int
+	bitmask uint32
+}
+
+func (k paramKey) String() string {
+	if k.bitmask == 0 {
+		return strconv.Itoa(k.id)
+	}
+	return fmt.Sprintf("%d[0x%x]", k.id, k.bitmask)
+}
+
+func compareParamKeys(a, b paramKey) int {
+	if a.id != b.id {
+		return a.id - b.id
+	}
+	return int(a.bitmask) - int(b.bitmask)
+}
+
+// valueID is the value of the parameter in zwave-js. zwave-js exposes
+// sub-parameters it knows about as values with the bitmask as the property
+// key.
+func (k paramKey) valueID() gozo.ValueID {
+	vid := gozo.ValueID{CommandClass: configurationCC, Property: k.id}
+	if k.bitmask != 0 {
+		vid.PropertyKey = k.bitmask
+	}
+	return vid
+}
+
+// paramKeyOf returns the parameter of a Configuration CC value, if the value
+// is one
+func paramKeyOf(vid gozo.ValueID) (paramKey, bool) {
+	if vid.CommandClass != configurationCC || vid.Endpoint != 0 {
+		return paramKey{}, false
+	}
+	id, ok := vid.Property.(float64)
+	if !ok {
+		return paramKey{}, false // e.g. resetting to defaults
+	}
+	k := paramKey{id: int(id)}
+	if mask, ok := vid.PropertyKey.(float64); ok {
+		k.bitmask = uint32(mask)
+	}
+	return k, true
+}
+
+type param struct {
+	description string
+	size        int // in bytes, 0 if the parameter is known to zwave-js
+	signed      bool
+	min, max    *int
+	allowed     []int
+	value       int64 // of the masked bits for sub-parameters
+}
+
+// raw reports whether the parameter is read and written whole, by
+// Configuration CC API, as zwave-js does not know its format
+func (p param) raw() bool {
+	return p.size != 0
+}
+
+func sizeMask(size int) uint32 {
+	return uint32(1<<(8*size) - 1)
+}
+
+// mask returns the bits of the whole parameter value that are the parameter
+func (p param) mask(k paramKey) uint32 {
+	if k.bitmask != 0 {
+		return k.bitmask
+	}
+	return sizeMask(p.size)
+}
+
+// width returns the number of bits in the value, or 0 if it is not known
+func (p param) width(k paramKey) int {
+	mask := p.mask(k)
+	return bits.Len32(mask >> bits.TrailingZeros32(mask))
+}
+
+// checkValue checks if the value fits into the parameter, and is accepted by
+// the device
+func (p param) checkValue(k paramKey, v int64) error {
+	w := p.width(k)
+	switch {
+	case p.min != nil && v < int64(*p.min):
+		return fmt.Errorf("value %d is less than minimum %d", v, *p.min)
+	case p.max != nil && v > int64(*p.max):
+		return fmt.Errorf("value %d is more than maximum %d", v, *p.max)
+	case p.allowed != nil && !slices.Contains(p.allowed, int(v)):
+		return fmt.Errorf("value %d is not one of allowed %v", v, p.allowed)
+	case w == 0 && !p.signed && v < 0:
+		return fmt.Errorf("value %d is negative, but the parameter is not signed", v)
+	case w == 0:
+		return nil
+	case p.signed && (v < -1<<(w-1) || v >= 1<<(w-1)):
+		return fmt.Errorf("value %d is out of %d-bit signed range", v, w)
+	case !p.signed && (v < 0 || v >= 1<<w):
+		return fmt.Errorf("value %d is out of %d-bit unsigned range", v, w)
+	}
+	return nil
+}
+
+// checkMetadata checks the values of parameters of the node against the
+// limits zwave-js knows for them. Parameters zwave-js does not know, or has no
+// metadata of yet, e.g. before the node is interviewed, are not checked.
+func checkMetadata(c *gozo.Conn, id int, node node) error {
+	mds := map[paramKey]gozo.ValueMetadata{}
+	for _, v := range c.Values(id) {
+		if k, ok := paramKeyOf(v.ValueID); ok {
+			mds[k] = v.Metadata
+		}
+	}
+
+	for _, k := range slices.SortedFunc(maps.Keys(node.params), compareParamKeys) {
+		md, ok := mds[k]
+		if !ok {
+			continue
+		}
+		v := node.params[k].value
+		_, named := md.States[strconv.FormatInt(v, 10)]
+		switch {
+		case md.Min != nil && float64(v) < *md.Min:
+			return fmt.Errorf("parameter %v: value %d is less than minimum %v known to zwave-js", k, v, *md.Min)
+		case md.Max != nil && float64(v) > *md.Max:
+			return fmt.Errorf("parameter %v: value %d is more than maximum %v known to zwave-js", k, v, *md.Max)
+		case len(md.States) != 0 && !md.AllowManualEntry && !named:
+			return fmt.Errorf("parameter %v: value %d is not one of %v known to zwave-js", k, v, stateValues(md))
+		}
+	}
+	return nil
+}
+
+// get extracts the value of the parameter from the whole parameter value
+func (p param) get(k paramKey, whole uint32) int64 {
+	mask := p.mask(k)
+	v := (whole & mask) >> bits.TrailingZeros32(mask)
+	if w := p.width(k); p.signed && v&(1<<(w-1)) != 0 {
+		return int64(v) - 1<<w
+	}
+	return int64(v)
+}
+
+// put returns the whole parameter value with the bits of the parameter set
+// to its value, and the rest kept
+func (p param) put(k paramKey, whole uint32) uint32 {
+	mask := p.mask(k)
+	return whole&^mask | uint32(p.value)<<bits.TrailingZeros32(mask)&mask
+}
+
+// valueState tells if the current value of a parameter is known
+type valueState int
+
+const (
+	valueKnown   valueState = iota
+	valueUnknown            // zwave-js has no usable value
+	valueUnread             // the value is not cached, and the node is asleep
+	valueFailed             // reading has failed
+)
+
+// paramValue is the current value of a parameter, of the masked bits for
+// sub-parameters
+type paramValue struct {
+	value int64
+	state valueState
+}
+
+func (v paramValue) String() string {
+	switch v.state {
+	case valueKnown:
+		return strconv.FormatInt(v.value, 10)
+	case valueUnread:
+		return "unread"
+	case valueFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// readParams returns the current values of parameters of the node, and the
+// whole values of raw parameters that have been read. Values come from the
+// cache of zwave-js, so reading them does not involve sleeping nodes.
+func readParams(ctx context.Context, c *gozo.Conn, id int, node node, asleep bool) (map[paramKey]paramValue, map[int]uint32) {
+	values := map[paramKey]paramValue{}
+	wholes := map[int]uint32{}
+	rawStates := map[int]valueState{} // of raw parameters that have been tried
+	for _, k := range slices.SortedFunc(maps.Keys(node.params), compareParamKeys) {
+		param := node.params[k]
+
+		if param.raw() {
+			st, tried := rawStates[k.id]
+			if !tried {
+				whole, known, err := readWhole(ctx, c, id, k.id, param.size, asleep)
+				switch {
+				case err != nil:
+					log.Printf("ERR: Failed to obtain current value %d (%s) %d: %v", id, node.description, k.id, err)
+					st = valueFailed
+				case !known:
+					st = valueUnread
+				default:
+					st = valueKnown
+					wholes[k.id] = whole
+				}
+				rawStates[k.id] = st
+			}
+			values[k] = paramValue{value: param.get(k, wholes[k.id]), state: st}
+			continue
+		}
+
+		anyValue, err := c.NodeGetValue(ctx, id, k.valueID())
+		if err != nil {
+			log.Printf("ERR: Failed to obtain current value %d (%s) %v (%s): %v", id, node.description, k, param.description, err)
+			values[k] = paramValue{state: valueFailed}
+			continue
+		}
+
+		switch v := anyValue.(type) {
+		case nil:
+			log.Printf("ERR: Empty current value %d (%s) %v (%s)", id, node.description, k, param.description)
+			values[k] = paramValue{state: valueUnknown}
+		case float64:
+			values[k] = paramValue{value: int64(v)}
+		default:
+			log.Printf("ERR: Unexpected current value %d (%s) %v (%s): %#v", id, node.description, k, param.description, anyValue)
+			values[k] = paramValue{state: valueUnknown}
+		}
+	}
+	return values, wholes
+}
+
+// readWhole returns the whole value of a raw parameter. zwave-js caches it
+// once read, so the node is asked only if it has not been. known is false if
+// the value is not cached and the node is asleep.
+func readWhole(ctx context.Context, c *gozo.Conn, id, n, size int, asleep bool) (whole uint32, known bool, err error) {
+	v, err := c.NodeGetValue(ctx, id, paramKey{id: n}.valueID())
+	if err != nil {
+		return 0, false, err
+	}
+	if v == nil {
+		if asleep {
+			return 0, false, nil
+		}
+		v, err = c.EndpointInvokeCCAPI(ctx, id, 0, configurationCC, "get", n)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, false, fmt.Errorf("unexpected value %#v", v)
+	}
+	// zwave-js reports parameters of unknown format as signed
+	return uint32(int64(f)) & sizeMask(size), true, nil
+}
+
+// writeWhole sets the whole value of a raw parameter
+func writeWhole(ctx context.Context, c *gozo.Conn, id, n, size int, whole uint32) error {
+	const unsigned = 1 // the bytes sent are the same for signed parameters
+	_, err := c.EndpointInvokeCCAPI(ctx, id, 0, configurationCC, "set", map[string]any{
+		"parameter":   n,
+		"value":       whole,
+		"valueSize":   size,
+		"valueFormat": unsigned,
+	})
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/gozotest"
+)
+
+func TestParseParams(t *testing.T) {
+	ptr := func(v int) *int { return &v }
+
+	tests := []struct {
+		name   string
+		params []configDeviceTypeParam
+		value  *configNodeParam
+		err    string
+	}{
+		{name: "sub-parameters", params: []configDeviceTypeParam{
+			{ID: 1, Bitmask: 0x0f, Size: 2, Default: ptr(15)},
+			{ID: 1, Bitmask: 0xf0, Size: 2, Signed: true, Default: ptr(-8)},
+		}},
+		{name: "bad size", params: []configDeviceTypeParam{{ID: 1, Size: 3}}, err: "size 3"},
+		{name: "bitmask out of size", params: []configDeviceTypeParam{{ID: 1, Bitmask: 0x100, Size: 1}}, err: "does not fit"},
+		{name: "overlap", params: []configDeviceTypeParam{
+			{ID: 1, Bitmask: 0x0f, Size: 1},
+			{ID: 1, Bitmask: 0x18, Size: 1},
+		}, err: "overlaps"},
+		{name: "whole and sub", params: []configDeviceTypeParam{
+			{ID: 1, Size: 1},
+			{ID: 1, Bitmask: 0x01, Size: 1},
+		}, err: "overlaps"},
+		{name: "sizes differ", params: []configDeviceTypeParam{
+			{ID: 1, Bitmask: 0x0f, Size: 1},
+			{ID: 1, Bitmask: 0xf0, Size: 2},
+		}, err: "size differs"},
+		{name: "default out of range", params: []configDeviceTypeParam{{ID: 1, Bitmask: 0x0f, Size: 1, Default: ptr(16)}}, err: "4-bit unsigned"},
+		{name: "signed default out of range", params: []configDeviceTypeParam{{ID: 1, Size: 1, Signed: true, Default: ptr(128)}}, err: "8-bit signed"},
+		{name: "negative unsigned", params: []configDeviceTypeParam{{ID: 1}}, value: &configNodeParam{ID: 1, Value: ptr(-1)}, err: "not signed"},
+		{name: "unknown sub-parameter", params: []configDeviceTypeParam{{ID: 1, Bitmask: 0x0f}}, value: &configNodeParam{ID: 1, Value: ptr(1)}, err: "not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cn := configNode{ID: 2, DeviceType: "dt"}
+			if tt.value != nil {
+				cn.Params = []configNodeParam{*tt.value}
+			}
+			_, err := parseConfig(config{
+				DeviceTypes: []configDeviceType{{Name: "dt", Params: tt.params}},
+				Nodes:       []configNode{cn},
+			})
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("parseConfig failed: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("parseConfig error is %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEnsureSubParams(t *testing.T) {
+	ptr := func(v int) *int { return &v }
+	nodes, err := parseConfig(config{
+		DeviceTypes: []configDeviceType{{
+			Name: "dt",
+			Params: []configDeviceTypeParam{
+				{ID: 5, Bitmask: 0x000f, Size: 2, Default: ptr(2)},
+				{ID: 5, Bitmask: 0x0f00, Size: 2, Signed: true, Default: ptr(-1)},
+				{ID: 6, Size: 1, Signed: true, Default: ptr(-2)},
+				{ID: 7, Bitmask: 0xff00, Default: ptr(10)},
+			},
+		}},
+		Nodes: []configNode{{ID: 2, DeviceType: "dt"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive})
+	srv.SetValue(2, paramKey{id: 5}.valueID(), 0x70a4)
+	srv.SetValue(2, paramKey{id: 6}.valueID(), -3) // as zwave-js reports parameters of unknown format
+	srv.SetValue(2, paramKey{id: 7, bitmask: 0xff00}.valueID(), 9)
+
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	changed, failed, dead, asleep := ensureNode(ctx, c, 2, nodes["2"])
+	if !changed || failed || dead || asleep {
+		t.Errorf("first pass: changed=%v failed=%v dead=%v asleep=%v, want only changed", changed, failed, dead, asleep)
+	}
+
+	var sets int
+	for _, req := range srv.Requests() {
+		if req.Command == "endpoint.invoke_cc_api" && req.Params["methodName"] == "set" {
+			sets++
+		}
+	}
+	if sets != 2 {
+		t.Errorf("first pass has set raw parameters %d times, want 2", sets)
+	}
+
+	for _, tt := range []struct {
+		key      paramKey
+		expected float64
+	}{
+		{key: paramKey{id: 5}, expected: 0x7fa2}, // bits outside of masks are kept
+		{key: paramKey{id: 6}, expected: 0xfe},
+		{key: paramKey{id: 7, bitmask: 0xff00}, expected: 10},
+	} {
+		if v, _ := srv.Value(2, tt.key.valueID()); v != tt.expected {
+			t.Errorf("parameter %v is %#v, want %#v", tt.key, v, tt.expected)
+		}
+	}
+
+	srv.ClearRequests()
+	changed, failed, dead, asleep = ensureNode(ctx, c, 2, nodes["2"])
+	if changed || failed || dead || asleep {
+		t.Errorf("second pass: changed=%v failed=%v dead=%v asleep=%v, want nothing", changed, failed, dead, asleep)
+	}
+}
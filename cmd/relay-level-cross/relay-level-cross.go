@@ -6,7 +6,6 @@ import (
 	"log"
 	"net/url"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
@@ -26,7 +25,7 @@ func realMain() int {
 
 	mqttAddr := os.Args[1]
 	zwaveJSAPIAddr := os.Args[2]
-	controllers := map[string]int{}
+	controllers := map[string]string{} // relayer -> relayee node reference
 
 	for _, arg := range os.Args[3:] {
 		from, to, ok := strings.Cut(arg, ":")
@@ -34,18 +33,24 @@ func realMain() int {
 			fmt.Fprintf(os.Stderr, "Failed to parse %q as controller:controllee\n", arg)
 			return 2
 		}
-		toInt := must.OK1(strconv.Atoi(to))
-		controllers[from] = toInt
-		fmt.Printf("relaying %s to %d\n", from, toInt)
+		controllers[from] = to
 	}
 
 	zc, err := gozo.NewConn(zwaveJSAPIAddr, func(m map[string]any) {})
 	if err != nil {
-		// TODO (dottedmag): Handle zwave-js API endpoint reconnections
 		log.Printf("FATAL: Failed to connect to zwave-js API endpoint %s: %v", zwaveJSAPIAddr, err)
 		os.Exit(1)
 	}
 
+	for from, to := range controllers {
+		nodeID, err := zc.ResolveNode(to)
+		if err != nil {
+			log.Printf("FATAL: Failed to resolve relayee of %s: %v", from, err)
+			os.Exit(1)
+		}
+		fmt.Printf("relaying %s to %s (node %d)\n", from, to, nodeID)
+	}
+
 	router := paho.NewStandardRouter()
 
 	cliCfg := autopaho.ClientConfig{
@@ -88,39 +93,47 @@ func realMain() int {
 	c := must.OK1(autopaho.NewConnection(ctx, cliCfg))
 	must.OK(c.AwaitConnection(ctx))
 
-	toggle := func(nodeID int) {
-		resp, err := zc.Call("node.get_value", map[string]any{
-			"nodeId": nodeID,
-			"valueId": map[string]any{
-				"commandClass": 37, // Binary Switch CC
-				"endpoint":     0,  // default, TODO: extend?
-				"property":     "currentValue",
-			},
-		})
+	toggle := func(ref string) {
+		// Resolve on every use, as the node might have been re-included
+		nodeID, err := zc.ResolveNode(ref)
 		if err != nil {
-			fmt.Printf("Failed to obtain state of a switch %d: %v\n", nodeID, err)
+			log.Printf("ERR: Failed to resolve switch %s: %v", ref, err)
 			return
 		}
-		fmt.Printf("%s received current value\n", time.Now().Format(time.RFC3339Nano))
 
-		if resp["success"] == nil || !resp["success"].(bool) {
-			log.Printf("ERR: Failed to obtain current value of a switch %d: %#v", nodeID, resp)
+		currentValue := gozo.ValueID{
+			CommandClass: 37, // Binary Switch CC
+			Endpoint:     0,  // default, TODO: extend?
+			Property:     "currentValue",
+		}
+
+		// Cached value saves a round trip, but it is not known until the
+		// switch has reported it at least once: until then it is either
+		// absent or present without a value
+		value, ok := zc.Value(nodeID, currentValue)
+		if !ok || value == nil {
+			value, err = zc.NodeGetValue(ctx, nodeID, currentValue)
+			if err != nil {
+				log.Printf("ERR: Failed to obtain current value of a switch %d: %v", nodeID, err)
+				return
+			}
+			fmt.Printf("%s received current value\n", time.Now().Format(time.RFC3339Nano))
 		}
 
-		value := resp["result"].(map[string]any)["value"].(bool)
-		newValue := !value
+		on, ok := value.(bool)
+		if !ok {
+			log.Printf("ERR: Unexpected current value of a switch %d: %#v", nodeID, value)
+			return
+		}
+		newValue := !on
 
 		fmt.Printf("toggling %d to %v\n", nodeID, newValue)
 
-		resp, err = zc.Call("node.set_value", map[string]any{
-			"nodeId": nodeID,
-			"valueId": map[string]any{
-				"commandClass": 37, // Configuration CC
-				"endpoint":     0,  // default, TODO:extend?
-				"property":     "targetValue",
-			},
-			"value": newValue,
-		})
+		err = zc.NodeSetValue(ctx, nodeID, gozo.ValueID{
+			CommandClass: 37, // Binary Switch CC
+			Endpoint:     0,  // default, TODO:extend?
+			Property:     "targetValue",
+		}, newValue)
 
 		fmt.Printf("toggled %d to %v\n", nodeID, newValue)
 
@@ -0,0 +1,7 @@
+package main
+
+import "github.com/dottedmag/gozo/internal/schedule"
+
+func main() {
+	schedule.Main("schedule", "binary-switch")
+}
@@ -2,12 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
-	"strconv"
+	"time"
 
 	"github.com/dottedmag/gozo"
-	"github.com/ridge/must/v2"
 	"golang.org/x/term"
 )
 
@@ -42,14 +42,10 @@ func getKey() string {
 }
 
 func setLevel(c *gozo.Conn, node int, level int) {
-	_, err := c.Call("node.set_value", map[string]any{
-		"nodeId": node,
-		"valueId": map[string]any{
-			"commandClass": 0x26, // Multilevel Switch CC
-			"property":     "targetValue",
-		},
-		"value": level,
-	})
+	err := c.NodeSetValue(context.Background(), node, gozo.ValueID{
+		CommandClass: 0x26, // Multilevel Switch CC
+		Property:     "targetValue",
+	}, level)
 	if err != nil {
 		panic(err)
 	}
@@ -64,23 +60,28 @@ func setLevel(c *gozo.Conn, node int, level int) {
 }
 
 func getLevel(c *gozo.Conn, node int) int {
-	resp, err := c.Call("node.get_value", map[string]any{
-		"nodeId": node,
-		"valueId": map[string]any{
-			"commandClass": 0x26,
-			"property":     "currentValue",
-		},
+	value, err := c.NodeGetValue(context.Background(), node, gozo.ValueID{
+		CommandClass: 0x26,
+		Property:     "currentValue",
 	})
 	if err != nil {
 		panic(err)
 	}
-	return int(resp["result"].(map[string]any)["value"].(float64))
+	level, ok := value.(float64)
+	if !ok {
+		panic(fmt.Errorf("unexpected level of node %d: %#v", node, value))
+	}
+	return int(level)
 }
 
 func main() {
-	id := int(must.OK1(strconv.ParseInt(os.Args[2], 10, 64)))
+	// Interactive tool: better report a failure than hang for long
+	c, err := gozo.NewConn(os.Args[1], func(m map[string]interface{}) {}, gozo.WithTimeout(2*time.Second))
+	if err != nil {
+		panic(err)
+	}
 
-	c, err := gozo.NewConn(os.Args[1], func(m map[string]interface{}) {})
+	id, err := c.ResolveNode(os.Args[2])
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,244 @@
+package gozo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ValueID identifies a value of a node
+type ValueID struct {
+	CommandClass int `json:"commandClass"`
+	Endpoint     int `json:"endpoint,omitempty"`
+	Property     any `json:"property"`
+	PropertyKey  any `json:"propertyKey,omitempty"`
+}
+
+// NodeStatus is the reachability of a node, as seen by zwave-js
+type NodeStatus int
+
+const (
+	NodeStatusUnknown NodeStatus = 0
+	NodeStatusAsleep  NodeStatus = 1
+	NodeStatusAwake   NodeStatus = 2
+	NodeStatusDead    NodeStatus = 3
+	NodeStatusAlive   NodeStatus = 4
+)
+
+func (s NodeStatus) String() string {
+	switch s {
+	case NodeStatusUnknown:
+		return "unknown"
+	case NodeStatusAsleep:
+		return "asleep"
+	case NodeStatusAwake:
+		return "awake"
+	case NodeStatusDead:
+		return "dead"
+	case NodeStatusAlive:
+		return "alive"
+	default:
+		return fmt.Sprintf("status(%d)", int(s))
+	}
+}
+
+// IsUp reports whether the node is expected to respond to commands, now or
+// once it wakes up
+func (s NodeStatus) IsUp() bool {
+	return s == NodeStatusAsleep || s == NodeStatusAwake || s == NodeStatusAlive
+}
+
+// NodeState is a subset of zwave-js node state
+type NodeState struct {
+	NodeID           int        `json:"nodeId"`
+	Name             string     `json:"name"`
+	Location         string     `json:"location"`
+	Status           NodeStatus `json:"status"`
+	Ready            bool       `json:"ready"`
+	IsControllerNode bool       `json:"isControllerNode"`
+
+	// Of the device, from zwave-js device config database
+	Label        string        `json:"label"`
+	DeviceConfig *DeviceConfig `json:"deviceConfig"`
+}
+
+// DeviceConfig is a subset of zwave-js device config of a node
+type DeviceConfig struct {
+	Manufacturer string `json:"manufacturer"`
+	Label        string `json:"label"`
+	Description  string `json:"description"`
+}
+
+// ControllerState is a subset of zwave-js controller state
+type ControllerState struct {
+	HomeID          int    `json:"homeId"`
+	OwnNodeID       int    `json:"ownNodeId"`
+	IsPrimary       bool   `json:"isPrimary"`
+	SDKVersion      string `json:"sdkVersion"`
+	FirmwareVersion string `json:"firmwareVersion"`
+}
+
+// DriverState is a subset of zwave-js driver state, without node values
+type DriverState struct {
+	Controller ControllerState `json:"controller"`
+	Nodes      []NodeState     `json:"nodes"`
+}
+
+// Successful values of SetValueStatus from zwave-js
+const (
+	setValueStatusWorking             = 1
+	setValueStatusSuccessUnsupervised = 254
+	setValueStatusSuccess             = 255
+)
+
+// NodeGetState returns the state of the node
+func (c *Conn) NodeGetState(ctx context.Context, nodeID int) (NodeState, error) {
+	var res struct {
+		State NodeState `json:"state"`
+	}
+	if err := c.do(ctx, "node.get_state", map[string]any{"nodeId": nodeID}, &res); err != nil {
+		return NodeState{}, err
+	}
+	return res.State, nil
+}
+
+// NodeGetValue returns the value cached by zwave-js.
+//
+// The value is decoded from JSON, so numbers are float64. The value is nil if
+// zwave-js does not know it.
+func (c *Conn) NodeGetValue(ctx context.Context, nodeID int, valueID ValueID) (any, error) {
+	var res struct {
+		Value any `json:"value"`
+	}
+	if err := c.do(ctx, "node.get_value", map[string]any{"nodeId": nodeID, "valueId": valueID}, &res); err != nil {
+		return nil, err
+	}
+	return res.Value, nil
+}
+
+// NodeSetValue sets the value and returns once the node has confirmed it, or
+// at least received the command
+func (c *Conn) NodeSetValue(ctx context.Context, nodeID int, valueID ValueID, value any) error {
+	var res struct {
+		// Schema versions before 29
+		Success *bool `json:"success"`
+		// Schema versions 29+
+		Result *struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"result"`
+	}
+	if err := c.do(ctx, "node.set_value", map[string]any{"nodeId": nodeID, "valueId": valueID, "value": value}, &res); err != nil {
+		return err
+	}
+
+	switch {
+	case res.Result != nil:
+		switch res.Result.Status {
+		case setValueStatusWorking, setValueStatusSuccess, setValueStatusSuccessUnsupervised:
+			return nil
+		default:
+			return fmt.Errorf("node.set_value failed with status %d: %s", res.Result.Status, res.Result.Message)
+		}
+	case res.Success != nil && !*res.Success:
+		return fmt.Errorf("node.set_value failed")
+	default:
+		return nil
+	}
+}
+
+// EndpointInvokeCCAPI calls a method of command class API of zwave-js and
+// returns its response
+func (c *Conn) EndpointInvokeCCAPI(ctx context.Context, nodeID, endpoint, commandClass int, methodName string, args ...any) (any, error) {
+	if args == nil {
+		args = []any{}
+	}
+
+	var res struct {
+		Response any `json:"response"`
+	}
+	if err := c.do(ctx, "endpoint.invoke_cc_api", map[string]any{
+		"nodeId":       nodeID,
+		"endpoint":     endpoint,
+		"commandClass": commandClass,
+		"methodName":   methodName,
+		"args":         args,
+	}, &res); err != nil {
+		return nil, err
+	}
+	return res.Response, nil
+}
+
+// ControllerGetState returns the state of the controller
+func (c *Conn) ControllerGetState(ctx context.Context) (ControllerState, error) {
+	var res struct {
+		State ControllerState `json:"state"`
+	}
+	if err := c.do(ctx, "controller.get_state", nil, &res); err != nil {
+		return ControllerState{}, err
+	}
+	return res.State, nil
+}
+
+// do sends a command over the current session and decodes the result into out
+func (c *Conn) do(ctx context.Context, command string, params map[string]any, out any) error {
+	ctx, cancel := c.withDefaultTimeout(ctx)
+	defer cancel()
+
+	s, err := c.session()
+	if err != nil {
+		return err
+	}
+	return c.command(ctx, s, command, params, out)
+}
+
+// command sends a command over session s and decodes the result into out,
+// unless out is nil
+func (c *Conn) command(ctx context.Context, s *session, command string, params map[string]any, out any) error {
+	data, err := c.call(ctx, s, command, params)
+	if err != nil {
+		return err
+	}
+
+	result, err := checkResponse(command, data)
+	if err != nil {
+		return err
+	}
+
+	if out == nil || len(result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(result, out); err != nil {
+		return fmt.Errorf("malformed result of %s: %w", command, err)
+	}
+	return nil
+}
+
+// checkResponse returns the result of a successful command, or *ServerError
+func checkResponse(command string, data []byte) (json.RawMessage, error) {
+	var resp struct {
+		Success           bool            `json:"success"`
+		Result            json.RawMessage `json:"result"`
+		ErrorCode         string          `json:"errorCode"`
+		Message           string          `json:"message"`
+		ZWaveErrorCode    int             `json:"zwaveErrorCode"`
+		ZWaveErrorMessage string          `json:"zwaveErrorMessage"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("malformed response to %s: %w", command, err)
+	}
+
+	if !resp.Success {
+		message := resp.Message
+		if message == "" {
+			message = resp.ZWaveErrorMessage
+		}
+		return nil, &ServerError{
+			Command:        command,
+			ErrorCode:      resp.ErrorCode,
+			Message:        message,
+			ZWaveErrorCode: resp.ZWaveErrorCode,
+		}
+	}
+	return resp.Result, nil
+}
@@ -0,0 +1,82 @@
+package gozo
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Some of error codes returned by zwave-js server
+const (
+	ErrorCodeUnknownCommand     = "unknown_command"
+	ErrorCodeNodeNotFound       = "node_not_found"
+	ErrorCodeSchemaIncompatible = "schema_incompatible"
+	ErrorCodeZWaveError         = "zwave_error"
+	ErrorCodeUnknownError       = "unknown_error"
+	ErrorCodeInvalidArgument    = "invalid_argument"
+)
+
+// Some of ZWaveErrorCodes from zwave-js, found in ServerError.ZWaveErrorCode
+// if ServerError.ErrorCode is ErrorCodeZWaveError
+const (
+	ZWaveErrorControllerTimeout        = 200
+	ZWaveErrorControllerNodeTimeout    = 201
+	ZWaveErrorControllerMessageDropped = 202
+	ZWaveErrorControllerResponseNOK    = 203
+	ZWaveErrorControllerCallbackNOK    = 204
+)
+
+// ServerError is returned if zwave-js server has failed to execute a command
+type ServerError struct {
+	Command        string
+	ErrorCode      string
+	Message        string
+	ZWaveErrorCode int
+}
+
+func (e *ServerError) Error() string {
+	msg := fmt.Sprintf("%s failed: %s", e.Command, e.ErrorCode)
+	if e.ErrorCode == ErrorCodeZWaveError {
+		msg += fmt.Sprintf(" %d", e.ZWaveErrorCode)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+func serverErrorCode(err error) (string, int, bool) {
+	var se *ServerError
+	if !errors.As(err, &se) {
+		return "", 0, false
+	}
+	return se.ErrorCode, se.ZWaveErrorCode, true
+}
+
+// IsUnknownCommand reports whether zwave-js server does not know the command,
+// likely because it is too old
+func IsUnknownCommand(err error) bool {
+	code, _, ok := serverErrorCode(err)
+	return ok && code == ErrorCodeUnknownCommand
+}
+
+// IsNodeNotFound reports whether the node addressed by the command does not
+// exist
+func IsNodeNotFound(err error) bool {
+	code, _, ok := serverErrorCode(err)
+	return ok && code == ErrorCodeNodeNotFound
+}
+
+// IsNodeDead reports whether the command has failed because the node is dead
+// or did not respond
+func IsNodeDead(err error) bool {
+	code, zwaveCode, ok := serverErrorCode(err)
+	if !ok || code != ErrorCodeZWaveError {
+		return false
+	}
+	switch zwaveCode {
+	case ZWaveErrorControllerNodeTimeout, ZWaveErrorControllerMessageDropped, ZWaveErrorControllerCallbackNOK:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,247 @@
+package gozo
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// Sources of events
+const (
+	SourceNode       = "node"
+	SourceController = "controller"
+	SourceDriver     = "driver"
+)
+
+// Names of events decoded into types
+const (
+	EventValueUpdated       = "value updated"
+	EventValueNotification  = "value notification"
+	EventAlive              = "alive"
+	EventDead               = "dead"
+	EventWakeUp             = "wake up"
+	EventSleep              = "sleep"
+	EventNodeAdded          = "node added"
+	EventNodeRemoved        = "node removed"
+	EventInterviewCompleted = "interview completed"
+)
+
+// Names of other events used to keep the mirrored driver state up to date
+const (
+	EventReady           = "ready"
+	EventValueAdded      = "value added"
+	EventValueRemoved    = "value removed"
+	EventMetadataUpdated = "metadata updated"
+)
+
+// Event is one of *ValueUpdatedEvent, *ValueNotificationEvent,
+// *NodeAliveEvent, *NodeDeadEvent, *NodeWakeUpEvent, *NodeSleepEvent,
+// *NodeAddedEvent, *NodeRemovedEvent, *InterviewCompletedEvent, or
+// *UnknownEvent for the rest
+type Event interface {
+	Header() EventHeader
+}
+
+// EventHeader is common to all events. NodeID is zero for events not related
+// to a node.
+type EventHeader struct {
+	Source string
+	Event  string
+	NodeID int
+}
+
+func (h EventHeader) Header() EventHeader {
+	return h
+}
+
+// ValueUpdatedEvent is sent when a value of a node changes
+type ValueUpdatedEvent struct {
+	EventHeader
+	ValueID   ValueID
+	NewValue  any
+	PrevValue any
+}
+
+// ValueNotificationEvent is sent for stateless values, such as scene activations
+type ValueNotificationEvent struct {
+	EventHeader
+	ValueID ValueID
+	Value   any
+}
+
+type NodeAliveEvent struct {
+	EventHeader
+}
+
+type NodeDeadEvent struct {
+	EventHeader
+}
+
+type NodeWakeUpEvent struct {
+	EventHeader
+}
+
+type NodeSleepEvent struct {
+	EventHeader
+}
+
+type NodeAddedEvent struct {
+	EventHeader
+	Node NodeState
+}
+
+type NodeRemovedEvent struct {
+	EventHeader
+	Node NodeState
+}
+
+type InterviewCompletedEvent struct {
+	EventHeader
+}
+
+// UnknownEvent is an event gozo does not decode
+type UnknownEvent struct {
+	EventHeader
+	Raw map[string]any
+}
+
+func decodeEvent(data []byte) (Event, error) {
+	var ev struct {
+		Source string    `json:"source"`
+		Event  string    `json:"event"`
+		NodeID int       `json:"nodeId"`
+		Node   NodeState `json:"node"`
+		Args   struct {
+			ValueID
+			NewValue  any `json:"newValue"`
+			PrevValue any `json:"prevValue"`
+			Value     any `json:"value"`
+		} `json:"args"`
+	}
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return nil, err
+	}
+
+	h := EventHeader{Source: ev.Source, Event: ev.Event, NodeID: ev.NodeID}
+
+	switch {
+	case h.Source == SourceNode && h.Event == EventValueUpdated:
+		return &ValueUpdatedEvent{EventHeader: h, ValueID: ev.Args.ValueID, NewValue: ev.Args.NewValue, PrevValue: ev.Args.PrevValue}, nil
+	case h.Source == SourceNode && h.Event == EventValueNotification:
+		return &ValueNotificationEvent{EventHeader: h, ValueID: ev.Args.ValueID, Value: ev.Args.Value}, nil
+	case h.Source == SourceNode && h.Event == EventAlive:
+		return &NodeAliveEvent{EventHeader: h}, nil
+	case h.Source == SourceNode && h.Event == EventDead:
+		return &NodeDeadEvent{EventHeader: h}, nil
+	case h.Source == SourceNode && h.Event == EventWakeUp:
+		return &NodeWakeUpEvent{EventHeader: h}, nil
+	case h.Source == SourceNode && h.Event == EventSleep:
+		return &NodeSleepEvent{EventHeader: h}, nil
+	case h.Source == SourceNode && h.Event == EventInterviewCompleted:
+		return &InterviewCompletedEvent{EventHeader: h}, nil
+	case h.Source == SourceController && h.Event == EventNodeAdded:
+		h.NodeID = ev.Node.NodeID
+		return &NodeAddedEvent{EventHeader: h, Node: ev.Node}, nil
+	case h.Source == SourceController && h.Event == EventNodeRemoved:
+		h.NodeID = ev.Node.NodeID
+		return &NodeRemovedEvent{EventHeader: h, Node: ev.Node}, nil
+	default:
+		var raw map[string]any
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		return &UnknownEvent{EventHeader: h, Raw: raw}, nil
+	}
+}
+
+// EventFilter selects events delivered to a subscriber. Empty fields match
+// any event.
+type EventFilter struct {
+	Source  string
+	Events  []string
+	NodeIDs []int
+}
+
+func (f EventFilter) matches(h EventHeader) bool {
+	if f.Source != "" && f.Source != h.Source {
+		return false
+	}
+	if len(f.Events) != 0 && !slices.Contains(f.Events, h.Event) {
+		return false
+	}
+	if len(f.NodeIDs) != 0 && !slices.Contains(f.NodeIDs, h.NodeID) {
+		return false
+	}
+	return true
+}
+
+const subscriberBuffer = 100
+
+type subscriber struct {
+	filter EventFilter
+	ch     chan Event
+}
+
+// Subscribe returns a channel of events matching the filter, and a function
+// to cancel the subscription and close the channel.
+//
+// Events are dropped if the subscriber does not keep up with them. Events
+// sent while the connection is down are lost. The channel is closed once Conn
+// ends.
+func (c *Conn) Subscribe(filter EventFilter) (<-chan Event, func()) {
+	sub := &subscriber{filter: filter, ch: make(chan Event, subscriberBuffer)}
+
+	c.mu.Lock()
+	if c.closed {
+		close(sub.ch)
+	} else {
+		c.subscribers[sub] = struct{}{}
+	}
+	c.mu.Unlock()
+
+	return sub.ch, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if _, ok := c.subscribers[sub]; ok {
+			delete(c.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+func (c *Conn) closeSubscribers() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for sub := range c.subscribers {
+		close(sub.ch)
+	}
+	c.subscribers = map[*subscriber]struct{}{}
+	c.closed = true
+}
+
+func (c *Conn) dispatchEvent(ev Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for sub := range c.subscribers {
+		if !sub.filter.matches(ev.Header()) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			log.Printf("gozo: subscriber is too slow, dropping %s", describeEvent(ev))
+		}
+	}
+}
+
+func describeEvent(ev Event) string {
+	h := ev.Header()
+	if h.NodeID != 0 {
+		return fmt.Sprintf("%s event %q of node %d", h.Source, h.Event, h.NodeID)
+	}
+	return fmt.Sprintf("%s event %q", h.Source, h.Event)
+}
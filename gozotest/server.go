@@ -0,0 +1,652 @@
+// Package gozotest provides an in-process fake of zwave-js server for tests
+package gozotest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dottedmag/gozo"
+	"github.com/gorilla/websocket"
+)
+
+// Handler handles a command instead of the built-in implementation. Returning
+// *gozo.ServerError makes the command fail with the given error code.
+type Handler func(params map[string]any) (result any, err error)
+
+// Request is a command received by Server
+type Request struct {
+	Command string
+	Params  map[string]any
+}
+
+type valueKey struct {
+	nodeID       int
+	commandClass int
+	endpoint     int
+	property     string
+	propertyKey  string
+}
+
+func keyOf(nodeID int, vid gozo.ValueID) valueKey {
+	k := valueKey{
+		nodeID:       nodeID,
+		commandClass: vid.CommandClass,
+		endpoint:     vid.Endpoint,
+		property:     fmt.Sprint(vid.Property),
+	}
+	if vid.PropertyKey != nil {
+		k.propertyKey = fmt.Sprint(vid.PropertyKey)
+	}
+	return k
+}
+
+type value struct {
+	id       gozo.ValueID
+	value    any
+	metadata *gozo.ValueMetadata
+}
+
+type client struct {
+	mu sync.Mutex // serializes writes
+	ws *websocket.Conn
+}
+
+func (c *client) send(msg any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_ = c.ws.WriteJSON(msg)
+}
+
+// Server is a scriptable fake of zwave-js server.
+//
+// It keeps a store of node values that is read and modified by node.get_value,
+// node.set_value and endpoint.invoke_cc_api of the command classes gozo tools
+// use. Modifications of values are announced with "value updated" events.
+type Server struct {
+	// URL of WebSocket API, to be passed to gozo.NewConn
+	URL string
+
+	srv *httptest.Server
+
+	mu               sync.Mutex
+	minSchemaVersion int
+	maxSchemaVersion int
+	controller       gozo.ControllerState
+	nodes            map[int]gozo.NodeState
+	nodeOrder        []int
+	values           map[valueKey]*value
+	valueOrder       []valueKey
+	handlers         map[string]Handler
+	requests         []Request
+	clients          map[*client]struct{}
+	running          chan struct{} // closed unless the server is stalled
+}
+
+// NewServer starts a server with the given nodes. The server is closed at the
+// end of the test.
+func NewServer(tb testing.TB, nodes ...gozo.NodeState) *Server {
+	s := &Server{
+		maxSchemaVersion: gozo.MaxSchemaVersion,
+		controller:       gozo.ControllerState{HomeID: 0xdeadbeef, OwnNodeID: 1, IsPrimary: true},
+		nodes:            map[int]gozo.NodeState{},
+		values:           map[valueKey]*value{},
+		handlers:         map[string]Handler{},
+		clients:          map[*client]struct{}{},
+		running:          make(chan struct{}),
+	}
+	close(s.running)
+	for _, n := range nodes {
+		s.addNode(n)
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = "ws" + strings.TrimPrefix(s.srv.URL, "http")
+	tb.Cleanup(s.Close)
+
+	return s
+}
+
+// Close drops connections and stops the server
+func (s *Server) Close() {
+	s.Resume()
+	s.DropConnections()
+	s.srv.Close()
+}
+
+// Stall makes the server hang, while keeping connections open: new
+// connections are not greeted, and neither commands nor pings on established
+// ones are answered, until Resume
+func (s *Server) Stall() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.running:
+		s.running = make(chan struct{})
+	default:
+	}
+}
+
+// Resume makes the stalled server carry on
+func (s *Server) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.running:
+	default:
+		close(s.running)
+	}
+}
+
+// wait blocks while the server is stalled
+func (s *Server) wait() {
+	s.mu.Lock()
+	running := s.running
+	s.mu.Unlock()
+
+	<-running
+}
+
+// SetSchemaVersions sets the range of API schema versions announced to new
+// connections
+func (s *Server) SetSchemaVersions(min, max int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.minSchemaVersion = min
+	s.maxSchemaVersion = max
+}
+
+// Handle overrides the handling of a command. Nil handler restores the
+// built-in one.
+func (s *Server) Handle(command string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if h == nil {
+		delete(s.handlers, command)
+	} else {
+		s.handlers[command] = h
+	}
+}
+
+// Fail makes every subsequent command fail with err, until Handle(command, nil)
+func (s *Server) Fail(command string, err *gozo.ServerError) {
+	s.Handle(command, func(map[string]any) (any, error) {
+		return nil, err
+	})
+}
+
+// Requests returns the commands received so far, excluding the handshake
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// ClearRequests forgets the commands received so far
+func (s *Server) ClearRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = nil
+}
+
+// Value returns the value from the store
+func (s *Server) Value(nodeID int, vid gozo.ValueID) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.values[keyOf(nodeID, vid)]
+	if !ok {
+		return nil, false
+	}
+	return v.value, true
+}
+
+// SetValue puts the value into the store, without sending an event
+func (s *Server) SetValue(nodeID int, vid gozo.ValueID, v any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setValue(nodeID, vid, v)
+}
+
+// SetValueMetadata sets the metadata of the value in the store, announced to
+// new connections
+func (s *Server) SetValueMetadata(nodeID int, vid gozo.ValueID, md gozo.ValueMetadata) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := keyOf(nodeID, vid)
+	if _, ok := s.values[k]; !ok {
+		s.setValue(nodeID, vid, nil)
+	}
+	s.values[k].metadata = &md
+}
+
+// UpdateValue puts the value into the store and sends "value updated" event,
+// as if the value was changed on the device
+func (s *Server) UpdateValue(nodeID int, vid gozo.ValueID, v any) {
+	s.mu.Lock()
+	prev := s.setValue(nodeID, vid, v)
+	s.mu.Unlock()
+
+	s.SendEvent(valueUpdatedEvent(nodeID, vid, prev, v))
+}
+
+// SetNodeStatus changes the status of the node and sends the corresponding
+// event. Commands sent to dead nodes fail.
+func (s *Server) SetNodeStatus(nodeID int, status gozo.NodeStatus) {
+	s.mu.Lock()
+	n := s.nodes[nodeID]
+	n.Status = status
+	s.nodes[nodeID] = n
+	s.mu.Unlock()
+
+	var event string
+	switch status {
+	case gozo.NodeStatusAsleep:
+		event = gozo.EventSleep
+	case gozo.NodeStatusAwake:
+		event = gozo.EventWakeUp
+	case gozo.NodeStatusDead:
+		event = gozo.EventDead
+	case gozo.NodeStatusAlive:
+		event = gozo.EventAlive
+	default:
+		return
+	}
+	s.SendEvent(map[string]any{"source": gozo.SourceNode, "event": event, "nodeId": nodeID})
+}
+
+// AddNode adds the node and sends "node added" event
+func (s *Server) AddNode(n gozo.NodeState) {
+	s.mu.Lock()
+	s.addNode(n)
+	s.mu.Unlock()
+
+	s.SendEvent(map[string]any{"source": gozo.SourceController, "event": gozo.EventNodeAdded, "node": n})
+}
+
+// RemoveNode removes the node and its values, and sends "node removed" event
+func (s *Server) RemoveNode(nodeID int) {
+	s.mu.Lock()
+	n := s.nodes[nodeID]
+	delete(s.nodes, nodeID)
+	s.nodeOrder = remove(s.nodeOrder, nodeID)
+	for k := range s.values {
+		if k.nodeID == nodeID {
+			delete(s.values, k)
+			s.valueOrder = remove(s.valueOrder, k)
+		}
+	}
+	s.mu.Unlock()
+
+	s.SendEvent(map[string]any{"source": gozo.SourceController, "event": gozo.EventNodeRemoved, "node": n})
+}
+
+// SendEvent sends the event to all connected clients
+func (s *Server) SendEvent(event map[string]any) {
+	s.Send(map[string]any{"type": "event", "event": event})
+}
+
+// Send sends an arbitrary message to all connected clients
+func (s *Server) Send(msg any) {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.send(msg)
+	}
+}
+
+// DropConnections closes all client connections, as if the server restarted
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.clients {
+		c.ws.Close()
+	}
+}
+
+// ConnectionCount returns the number of currently connected clients
+func (s *Server) ConnectionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.clients)
+}
+
+func (s *Server) addNode(n gozo.NodeState) {
+	if _, ok := s.nodes[n.NodeID]; !ok {
+		s.nodeOrder = append(s.nodeOrder, n.NodeID)
+	}
+	s.nodes[n.NodeID] = n
+}
+
+func (s *Server) setValue(nodeID int, vid gozo.ValueID, v any) any {
+	k := keyOf(nodeID, vid)
+	old, ok := s.values[k]
+	if !ok {
+		s.values[k] = &value{id: vid, value: v}
+		s.valueOrder = append(s.valueOrder, k)
+		return nil
+	}
+	prev := old.value
+	old.value = v
+	return prev
+}
+
+func remove[T comparable](s []T, v T) []T {
+	out := s[:0]
+	for _, e := range s {
+		if e != v {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func valueUpdatedEvent(nodeID int, vid gozo.ValueID, prev, v any) map[string]any {
+	args := map[string]any{
+		"commandClass": vid.CommandClass,
+		"endpoint":     vid.Endpoint,
+		"property":     vid.Property,
+		"newValue":     v,
+		"prevValue":    prev,
+	}
+	if vid.PropertyKey != nil {
+		args["propertyKey"] = vid.PropertyKey
+	}
+	return map[string]any{"source": gozo.SourceNode, "event": gozo.EventValueUpdated, "nodeId": nodeID, "args": args}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &client{ws: ws}
+
+	s.mu.Lock()
+	s.clients[c] = struct{}{}
+	version := map[string]any{
+		"type":             "version",
+		"driverVersion":    "gozotest",
+		"serverVersion":    "gozotest",
+		"homeId":           s.controller.HomeID,
+		"minSchemaVersion": s.minSchemaVersion,
+		"maxSchemaVersion": s.maxSchemaVersion,
+	}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		ws.Close()
+	}()
+
+	ws.SetPingHandler(func(data string) error {
+		s.wait()
+		return ws.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	s.wait()
+	c.send(version)
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.wait()
+
+		var msg map[string]any
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return
+		}
+		command, _ := msg["command"].(string)
+		delete(msg, "command")
+		messageID := msg["messageId"]
+		delete(msg, "messageId")
+
+		result, events, err := s.handle(command, msg)
+
+		var se *gozo.ServerError
+		switch {
+		case err == nil:
+			c.send(map[string]any{"type": "result", "messageId": messageID, "success": true, "result": result})
+		case errors.As(err, &se):
+			resp := map[string]any{"type": "result", "messageId": messageID, "success": false, "errorCode": se.ErrorCode}
+			if se.ErrorCode == gozo.ErrorCodeZWaveError {
+				resp["zwaveErrorCode"] = se.ZWaveErrorCode
+				resp["zwaveErrorMessage"] = se.Message
+			} else {
+				resp["message"] = se.Message
+			}
+			c.send(resp)
+		default:
+			c.send(map[string]any{"type": "result", "messageId": messageID, "success": false, "errorCode": gozo.ErrorCodeUnknownError, "message": err.Error()})
+		}
+
+		for _, ev := range events {
+			s.SendEvent(ev)
+		}
+	}
+}
+
+func (s *Server) handle(command string, params map[string]any) (any, []map[string]any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch command {
+	case "set_api_schema":
+		return map[string]any{}, nil, nil
+	case "start_listening":
+		return map[string]any{"state": s.state()}, nil, nil
+	}
+
+	s.requests = append(s.requests, Request{Command: command, Params: params})
+
+	if h := s.handlers[command]; h != nil {
+		s.mu.Unlock()
+		res, err := h(params)
+		s.mu.Lock()
+		return res, nil, err
+	}
+
+	switch command {
+	case "controller.get_state":
+		return map[string]any{"state": s.controller}, nil, nil
+	case "node.get_state":
+		n, err := s.node(params)
+		if err != nil {
+			return nil, nil, err
+		}
+		return map[string]any{"state": n}, nil, nil
+	case "node.get_value":
+		n, err := s.node(params)
+		if err != nil {
+			return nil, nil, err
+		}
+		vid, err := decodeValueID(params["valueId"])
+		if err != nil {
+			return nil, nil, err
+		}
+		var v any
+		if val := s.values[keyOf(n.NodeID, vid)]; val != nil {
+			v = val.value
+		}
+		return map[string]any{"value": v}, nil, nil
+	case "node.set_value":
+		n, err := s.aliveNode(params)
+		if err != nil {
+			return nil, nil, err
+		}
+		vid, err := decodeValueID(params["valueId"])
+		if err != nil {
+			return nil, nil, err
+		}
+		events := s.set(n.NodeID, vid, params["value"])
+		if vid.Property == "targetValue" {
+			vid.Property = "currentValue"
+			events = append(events, s.set(n.NodeID, vid, params["value"])...)
+		}
+		return map[string]any{"result": map[string]any{"status": 255}}, events, nil
+	case "endpoint.invoke_cc_api":
+		n, err := s.aliveNode(params)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s.invokeCCAPI(n.NodeID, params)
+	default:
+		return nil, nil, &gozo.ServerError{Command: command, ErrorCode: gozo.ErrorCodeUnknownCommand, Message: "Unknown command"}
+	}
+}
+
+// set modifies the store on behalf of a client, returning the events to send
+func (s *Server) set(nodeID int, vid gozo.ValueID, v any) []map[string]any {
+	prev := s.setValue(nodeID, vid, v)
+	if reflect.DeepEqual(prev, v) {
+		return nil
+	}
+	return []map[string]any{valueUpdatedEvent(nodeID, vid, prev, v)}
+}
+
+func (s *Server) invokeCCAPI(nodeID int, params map[string]any) (any, []map[string]any, error) {
+	cc := toInt(params["commandClass"])
+	endpoint := toInt(params["endpoint"])
+	method, _ := params["methodName"].(string)
+	args, _ := params["args"].([]any)
+
+	vid := func(property any) gozo.ValueID {
+		return gozo.ValueID{CommandClass: cc, Endpoint: endpoint, Property: property}
+	}
+
+	var events []map[string]any
+	switch {
+	case (cc == 0x25 || cc == 0x26) && method == "set" && len(args) >= 1: // Binary Switch, Multilevel Switch
+		events = append(events, s.set(nodeID, vid("targetValue"), args[0])...)
+		events = append(events, s.set(nodeID, vid("currentValue"), args[0])...)
+		return map[string]any{}, events, nil
+	case cc == 0x40 && method == "set" && len(args) >= 1: // Thermostat Mode
+		events = s.set(nodeID, vid("mode"), args[0])
+		return map[string]any{}, events, nil
+	case cc == 0x43 && method == "set" && len(args) >= 2: // Thermostat Setpoint
+		v := vid("setpoint")
+		v.PropertyKey = args[0]
+		events = s.set(nodeID, v, args[1])
+		return map[string]any{}, events, nil
+	case cc == 0x70 && method == "get" && len(args) >= 1: // Configuration
+		var v any
+		if val := s.values[keyOf(nodeID, vid(args[0]))]; val != nil {
+			v = val.value
+		}
+		return map[string]any{"response": v}, nil, nil
+	case cc == 0x70 && method == "set" && len(args) >= 1:
+		opts, _ := args[0].(map[string]any)
+		events = s.set(nodeID, vid(opts["parameter"]), opts["value"])
+		return map[string]any{}, events, nil
+	default:
+		return map[string]any{}, nil, nil
+	}
+}
+
+func (s *Server) state() map[string]any {
+	var nodes []map[string]any
+	for _, id := range s.nodeOrder {
+		n := s.nodes[id]
+		values := []map[string]any{}
+		for _, k := range s.valueOrder {
+			if k.nodeID != id {
+				continue
+			}
+			v := s.values[k]
+			vm := map[string]any{
+				"commandClass": v.id.CommandClass,
+				"endpoint":     v.id.Endpoint,
+				"property":     v.id.Property,
+				"value":        v.value,
+			}
+			if v.id.PropertyKey != nil {
+				vm["propertyKey"] = v.id.PropertyKey
+			}
+			if v.metadata != nil {
+				vm["metadata"] = v.metadata
+			}
+			values = append(values, vm)
+		}
+		nodes = append(nodes, map[string]any{
+			"nodeId":           n.NodeID,
+			"name":             n.Name,
+			"location":         n.Location,
+			"status":           n.Status,
+			"ready":            n.Ready,
+			"isControllerNode": n.IsControllerNode,
+			"label":            n.Label,
+			"deviceConfig":     n.DeviceConfig,
+			"values":           values,
+		})
+	}
+	return map[string]any{"controller": s.controller, "nodes": nodes}
+}
+
+func (s *Server) node(params map[string]any) (gozo.NodeState, error) {
+	id := toInt(params["nodeId"])
+	n, ok := s.nodes[id]
+	if !ok {
+		return gozo.NodeState{}, &gozo.ServerError{ErrorCode: gozo.ErrorCodeNodeNotFound, Message: fmt.Sprintf("Node %d not found", id)}
+	}
+	return n, nil
+}
+
+func (s *Server) aliveNode(params map[string]any) (gozo.NodeState, error) {
+	n, err := s.node(params)
+	if err != nil {
+		return n, err
+	}
+	if n.Status == gozo.NodeStatusDead {
+		return n, &gozo.ServerError{
+			ErrorCode:      gozo.ErrorCodeZWaveError,
+			ZWaveErrorCode: gozo.ZWaveErrorControllerMessageDropped,
+			Message:        fmt.Sprintf("The message cannot be sent because node %d is dead", n.NodeID),
+		}
+	}
+	return n, nil
+}
+
+func decodeValueID(v any) (gozo.ValueID, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return gozo.ValueID{}, &gozo.ServerError{ErrorCode: gozo.ErrorCodeInvalidArgument, Message: "valueId is missing"}
+	}
+	return gozo.ValueID{
+		CommandClass: toInt(m["commandClass"]),
+		Endpoint:     toInt(m["endpoint"]),
+		Property:     m["property"],
+		PropertyKey:  m["propertyKey"],
+	}, nil
+}
+
+func toInt(v any) int {
+	f, _ := v.(float64)
+	return int(f)
+}
@@ -0,0 +1,20 @@
+// Package cli has helpers shared by command-line interfaces of the tools
+package cli
+
+import "flag"
+
+// ParseFlags parses the flags, which may go before or after positional
+// arguments, and returns the positional arguments
+func ParseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
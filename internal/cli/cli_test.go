@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"flag"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		args       []string
+		positional []string
+		days       int
+		err        bool
+	}{
+		{args: []string{"config.toml"}, positional: []string{"config.toml"}, days: 7},
+		{args: []string{"--days", "3", "config.toml"}, positional: []string{"config.toml"}, days: 3},
+		{args: []string{"config.toml", "--days=3"}, positional: []string{"config.toml"}, days: 3},
+		{args: []string{"a", "--days", "3", "b"}, positional: []string{"a", "b"}, days: 3},
+		{args: nil, days: 7},
+		{args: []string{"config.toml", "--weeks", "3"}, err: true},
+	}
+
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		days := fs.Int("days", 7, "")
+
+		positional, err := ParseFlags(fs, tt.args)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseFlags(%q) error = %v", tt.args, err)
+			continue
+		}
+		if !tt.err && (!slices.Equal(positional, tt.positional) || *days != tt.days) {
+			t.Errorf("ParseFlags(%q) = %q, days %d, want %q, days %d", tt.args, positional, *days, tt.positional, tt.days)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Package configfile loads TOML configs of the tools and watches them for
+// changes
+package configfile
+
+import (
+	"maps"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Load decodes the config file, rejecting unknown fields
+func Load(path string, v any) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	dec := toml.NewDecoder(fh)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// Watch notifies about changes of the file and about SIGHUP. The file is
+// checked every interval, so that it works with editors that replace files
+// instead of writing them.
+func Watch(path string, interval time.Duration) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	last, _ := os.Stat(path)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hup:
+				notify()
+			case <-ticker.C:
+				fi, err := os.Stat(path)
+				if err != nil {
+					continue // might be in the middle of being replaced
+				}
+				if last == nil || !fi.ModTime().Equal(last.ModTime()) || fi.Size() != last.Size() {
+					last = fi
+					notify()
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// Diff compares nodes of two configs, keyed by node reference
+func Diff[N any](prev, next map[string]N) (added, removed, changed []string) {
+	for _, ref := range slices.Sorted(maps.Keys(next)) {
+		p, ok := prev[ref]
+		switch {
+		case !ok:
+			added = append(added, ref)
+		case !reflect.DeepEqual(p, next[ref]):
+			changed = append(changed, ref)
+		}
+	}
+	for _, ref := range slices.Sorted(maps.Keys(prev)) {
+		if _, ok := next[ref]; !ok {
+			removed = append(removed, ref)
+		}
+	}
+	return added, removed, changed
+}
@@ -0,0 +1,84 @@
+package configfile
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+
+	var c struct {
+		Timezone string `toml:"timezone"`
+	}
+	if err := os.WriteFile(path, []byte(`timezone = "Europe/Berlin"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path, &c); err != nil || c.Timezone != "Europe/Berlin" {
+		t.Errorf("Load = %v, timezone %q", err, c.Timezone)
+	}
+
+	if err := os.WriteFile(path, []byte(`time_zone = "Europe/Berlin"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path, &c); err == nil {
+		t.Errorf("Load of config with unknown field succeeded")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("a = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := Watch(path, 10*time.Millisecond)
+	expect := func(what string) {
+		t.Helper()
+		select {
+		case <-changes:
+		case <-time.After(time.Second):
+			t.Fatalf("no notification of %s", what)
+		}
+	}
+
+	// Replaced, as editors do
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte("a = 22\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	expect("file change")
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	expect("SIGHUP")
+
+	select {
+	case <-changes:
+		t.Errorf("notification without changes")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type node struct{ params []int }
+	prev := map[string]node{"2": {params: []int{1}}, "3": {params: []int{1}}, "lamp": {}}
+	next := map[string]node{"2": {params: []int{1}}, "3": {params: []int{2}}, "4": {}}
+
+	added, removed, changed := Diff(prev, next)
+	if !slices.Equal(added, []string{"4"}) || !slices.Equal(removed, []string{"lamp"}) || !slices.Equal(changed, []string{"3"}) {
+		t.Errorf("Diff = %v, %v, %v, want [4], [lamp], [3]", added, removed, changed)
+	}
+}
@@ -0,0 +1,112 @@
+// Package health tracks reachability of nodes, so that unreachable ones are
+// retried with exponential backoff instead of on every pass
+package health
+
+import "time"
+
+// Default delays of the tools: retries of failed nodes back off from
+// DefaultMin to DefaultMax, and dead nodes are parked for DefaultMax
+const (
+	DefaultMin = 10 * time.Second
+	DefaultMax = time.Hour
+)
+
+// Tracker keeps the health of nodes. Nodes it has nothing recorded about are
+// healthy.
+type Tracker struct {
+	min, max time.Duration
+	nodes    map[int]status
+}
+
+type status struct {
+	failures int // in a row
+	dead     bool
+	retry    time.Time
+}
+
+// New returns a tracker that retries failed nodes after min, doubling the
+// delay up to max. Dead nodes are parked for max.
+func New(min, max time.Duration) *Tracker {
+	return &Tracker{min: min, max: max, nodes: map[int]status{}}
+}
+
+// Healthy reports whether the last handling of the node succeeded
+func (t *Tracker) Healthy(id int) bool {
+	_, ok := t.nodes[id]
+	return !ok
+}
+
+// Dead reports whether the node is parked as dead
+func (t *Tracker) Dead(id int) bool {
+	return t.nodes[id].dead
+}
+
+// Due reports whether the node is to be handled at the time. Healthy nodes
+// always are.
+func (t *Tracker) Due(id int, now time.Time) bool {
+	s, ok := t.nodes[id]
+	return !ok || !now.Before(s.retry)
+}
+
+// Next returns the time of the earliest retry, or zero time if all nodes are
+// healthy
+func (t *Tracker) Next() time.Time {
+	var next time.Time
+	for _, s := range t.nodes {
+		if next.IsZero() || s.retry.Before(next) {
+			next = s.retry
+		}
+	}
+	return next
+}
+
+// OK records a successful handling of the node
+func (t *Tracker) OK(id int) {
+	delete(t.nodes, id)
+}
+
+// Failed records a failed handling of the node, and returns the time of the
+// retry
+func (t *Tracker) Failed(id int, now time.Time) time.Time {
+	s := t.nodes[id]
+	s.failures++
+	s.dead = false
+
+	// Checked before shifting, as the shifted delay overflows after a few
+	// dozen failures
+	delay := t.max
+	if n := s.failures - 1; t.min <= t.max>>n {
+		delay = t.min << n
+	}
+	s.retry = now.Add(delay)
+	t.nodes[id] = s
+	return s.retry
+}
+
+// Park records that the node is dead. It is retried once it is alive again,
+// or after the maximum delay in case the news are missed.
+func (t *Tracker) Park(id int, now time.Time) time.Time {
+	s := t.nodes[id]
+	s.dead = true
+	s.retry = now.Add(t.max)
+	t.nodes[id] = s
+	return s.retry
+}
+
+// Alive records that the node has been heard from, so it is to be retried
+// right away. Returns false if the node is healthy and needs no retry.
+func (t *Tracker) Alive(id int) bool {
+	s, ok := t.nodes[id]
+	if !ok {
+		return false
+	}
+	s.dead = false
+	s.retry = time.Time{}
+	t.nodes[id] = s
+	return true
+}
+
+// Forget drops the record of the node, e.g. when it is no longer serviced
+func (t *Tracker) Forget(id int) {
+	delete(t.nodes, id)
+}
@@ -0,0 +1,61 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := New(10*time.Second, time.Minute)
+
+	if !tr.Healthy(1) || !tr.Due(1, start) || !tr.Next().IsZero() {
+		t.Fatalf("unknown node is not healthy")
+	}
+
+	// Backoff doubles up to the maximum
+	now := start
+	for _, expected := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		retry := tr.Failed(1, now)
+		if retry.Sub(now) != expected {
+			t.Errorf("retry in %v, want %v", retry.Sub(now), expected)
+		}
+		if tr.Due(1, now) || !tr.Due(1, retry) || !tr.Next().Equal(retry) {
+			t.Errorf("node is due before retry at %v", retry)
+		}
+		now = retry
+	}
+
+	// and stays there however many failures there are
+	for range 100 {
+		if retry := tr.Failed(1, now); retry.Sub(now) != time.Minute {
+			t.Fatalf("retry in %v, want %v", retry.Sub(now), time.Minute)
+		}
+	}
+
+	tr.OK(1)
+	if !tr.Healthy(1) || tr.Failed(1, now).Sub(now) != 10*time.Second {
+		t.Errorf("success does not reset backoff")
+	}
+
+	// Dead nodes are parked until alive
+	tr.Park(2, now)
+	if !tr.Dead(2) || tr.Due(2, now.Add(59*time.Second)) || !tr.Due(2, now.Add(time.Minute)) {
+		t.Errorf("dead node is not parked for the maximum delay")
+	}
+	if !tr.Next().Equal(now.Add(10 * time.Second)) {
+		t.Errorf("next retry is %v, want the one of the failed node", tr.Next())
+	}
+	if !tr.Alive(2) || tr.Dead(2) || !tr.Due(2, now) {
+		t.Errorf("alive node is not retried right away")
+	}
+	tr.OK(2)
+	if tr.Alive(2) {
+		t.Errorf("healthy node needs a retry on alive")
+	}
+
+	tr.Forget(1)
+	if !tr.Healthy(1) || !tr.Next().IsZero() {
+		t.Errorf("forgotten node is not healthy")
+	}
+}
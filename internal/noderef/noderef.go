@@ -0,0 +1,41 @@
+// Package noderef handles references to nodes in configs of the tools: a node
+// ID or a name
+package noderef
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/dottedmag/gozo"
+)
+
+// Ref returns the reference to the node configured by either ID or name
+func Ref(id int, name string) (string, error) {
+	switch {
+	case id != 0 && name != "":
+		return "", fmt.Errorf("node %d has both id and name %q", id, name)
+	case id != 0:
+		return strconv.Itoa(id), nil
+	case name != "":
+		return name, nil
+	default:
+		return "", fmt.Errorf("node has neither id nor name")
+	}
+}
+
+// Resolve finds IDs of configured nodes in the driver state, rejecting
+// different references to the same node
+func Resolve[T any](c *gozo.Conn, nodes map[string]T) (map[int]T, error) {
+	out := map[int]T{}
+	for ref, node := range nodes {
+		id, err := c.ResolveNode(ref)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := out[id]; ok {
+			return nil, fmt.Errorf("node %d is present multiple times in config, last as %s", id, ref)
+		}
+		out[id] = node
+	}
+	return out, nil
+}
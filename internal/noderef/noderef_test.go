@@ -0,0 +1,51 @@
+package noderef
+
+import (
+	"testing"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/gozotest"
+)
+
+func TestRef(t *testing.T) {
+	for _, tc := range []struct {
+		id       int
+		name     string
+		expected string
+		err      bool
+	}{
+		{id: 3, expected: "3"},
+		{name: "hall/lamp", expected: "hall/lamp"},
+		{id: 3, name: "lamp", err: true},
+		{err: true},
+	} {
+		ref, err := Ref(tc.id, tc.name)
+		if ref != tc.expected || (err != nil) != tc.err {
+			t.Errorf("Ref(%d, %q) = %q, %v", tc.id, tc.name, ref, err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	srv := gozotest.NewServer(t,
+		gozo.NodeState{NodeID: 2, Name: "lamp", Location: "hall"},
+		gozo.NodeState{NodeID: 3, Name: "heater"},
+	)
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	resolved, err := Resolve(c, map[string]string{"hall/lamp": "a", "3": "b"})
+	if err != nil || len(resolved) != 2 || resolved[2] != "a" || resolved[3] != "b" {
+		t.Errorf("Resolve = %v, %v", resolved, err)
+	}
+
+	if _, err := Resolve(c, map[string]string{"lamp": "a", "2": "b"}); err == nil {
+		t.Errorf("Resolve of the same node referenced twice succeeded")
+	}
+	if _, err := Resolve(c, map[string]string{"fridge": "a"}); err == nil {
+		t.Errorf("Resolve of unknown node succeeded")
+	}
+}
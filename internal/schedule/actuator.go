@@ -0,0 +1,216 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dottedmag/gozo"
+)
+
+// actuator is the way of putting a node into scheduled states
+type actuator interface {
+	// parseState returns the state the event puts the node into
+	parseState(cev configScheduleEvent) (state, error)
+
+	// currentState returns the state of the node as known to zwave-js
+	currentState(c *gozo.Conn, id int) state
+
+	// updateState returns the state after the value update, if it is about
+	// the state
+	updateState(s state, vid gozo.ValueID, v any) (state, bool)
+
+	// setState sends the commands to put the node into the state of the event
+	setState(ctx context.Context, c *gozo.Conn, id int, e timedEvent) error
+}
+
+// state is a state of a node, of the type specific to its actuator
+type state interface {
+	// satisfies checks if the node in the state does not need to be
+	// transitioned into the target
+	satisfies(target state) bool
+
+	// apply returns the state after transitioning into the target
+	apply(target state) state
+
+	String() string
+}
+
+type unknownState struct{}
+
+var unknown state = unknownState{}
+
+func (unknownState) satisfies(state) bool     { return false }
+func (unknownState) apply(target state) state { return target }
+func (unknownState) String() string           { return "unknown" }
+
+const (
+	binarySwitch     = 0x25
+	multilevelSwitch = 0x26
+)
+
+// switchActuator sets Binary Switch or Multilevel Switch
+type switchActuator struct {
+	commandClass int
+	endpoint     int
+}
+
+func (a switchActuator) dimmer() bool {
+	return a.commandClass == multilevelSwitch
+}
+
+// switchState is off, on, or a dimmer level as "42%"
+type switchState string
+
+const (
+	off switchState = "off"
+	on  switchState = "on"
+)
+
+func levelState(level int) switchState {
+	if level == 0 {
+		return off
+	}
+	return switchState(strconv.Itoa(level) + "%")
+}
+
+func (s switchState) level() (int, bool) {
+	l, err := strconv.Atoi(strings.TrimSuffix(string(s), "%"))
+	return l, err == nil
+}
+
+// satisfies treats any level as "on", as "on" turns a dimmer on at its last
+// level
+func (s switchState) satisfies(target state) bool {
+	_, isLevel := s.level()
+	return s == target || target == on && isLevel
+}
+
+func (s switchState) apply(target state) state { return target }
+func (s switchState) String() string           { return string(s) }
+
+func (a switchActuator) parseState(cev configScheduleEvent) (state, error) {
+	if cev.Mode != nil || cev.Setpoint != nil || cev.SetpointType != "" || cev.Args != nil {
+		return nil, fmt.Errorf("switches support only on, level and duration")
+	}
+	switch {
+	case cev.Level != nil && !a.dimmer():
+		return nil, fmt.Errorf("levels need command_class = 0x%x", multilevelSwitch)
+	case cev.Level != nil && cev.On:
+		return nil, fmt.Errorf("both on and level are set")
+	case cev.Level != nil:
+		if *cev.Level < 0 || *cev.Level > 99 {
+			return nil, fmt.Errorf("level %d is out of 0-99 range", *cev.Level)
+		}
+		return levelState(*cev.Level), nil
+	case cev.On:
+		return on, nil
+	default:
+		return off, nil
+	}
+}
+
+// value is the value that reflects the state of the node
+func (a switchActuator) value() gozo.ValueID {
+	return gozo.ValueID{CommandClass: a.commandClass, Endpoint: a.endpoint, Property: "currentValue"}
+}
+
+func valueState(v any) state {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return on
+		}
+		return off
+	case float64:
+		return levelState(int(v))
+	default:
+		return unknown
+	}
+}
+
+func (a switchActuator) currentState(c *gozo.Conn, id int) state {
+	v, _ := c.Value(id, a.value())
+	return valueState(v)
+}
+
+func (a switchActuator) updateState(s state, vid gozo.ValueID, v any) (state, bool) {
+	value := a.value()
+	if vid.CommandClass != value.CommandClass || vid.Endpoint != value.Endpoint || vid.Property != value.Property {
+		return s, false
+	}
+	newState := valueState(v)
+	return newState, newState != unknown
+}
+
+func (a switchActuator) setState(ctx context.Context, c *gozo.Conn, id int, e timedEvent) error {
+	var targetValue any = e.state == on
+	if a.dimmer() {
+		switch level, ok := e.state.(switchState).level(); {
+		case ok:
+			targetValue = level
+		case e.state == on:
+			targetValue = 255 // last non-zero level
+		default:
+			targetValue = 0
+		}
+	}
+
+	args := []any{targetValue}
+	if e.duration != 0 {
+		args = append(args, e.duration.String())
+	}
+
+	_, err := c.EndpointInvokeCCAPI(ctx, id, a.endpoint, a.commandClass, "set", args...)
+	return err
+}
+
+// invokeActuator calls an arbitrary CC API method with the arguments of the
+// event. The state of the node cannot be read back, so manual changes are not
+// detected.
+type invokeActuator struct {
+	commandClass int
+	endpoint     int
+	method       string
+}
+
+// invokeState is the arguments, as JSON
+type invokeState string
+
+func (s invokeState) satisfies(target state) bool { return s == target }
+func (s invokeState) apply(target state) state    { return target }
+func (s invokeState) String() string              { return string(s) }
+
+func (a invokeActuator) parseState(cev configScheduleEvent) (state, error) {
+	if cev.On || cev.Level != nil || cev.Duration != "" || cev.Mode != nil || cev.Setpoint != nil || cev.SetpointType != "" {
+		return nil, fmt.Errorf("invoke actuator supports only args")
+	}
+	args := cev.Args
+	if args == nil {
+		args = []any{}
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid args: %v", err)
+	}
+	return invokeState(data), nil
+}
+
+func (a invokeActuator) currentState(*gozo.Conn, int) state {
+	return unknown
+}
+
+func (a invokeActuator) updateState(s state, _ gozo.ValueID, _ any) (state, bool) {
+	return s, false
+}
+
+func (a invokeActuator) setState(ctx context.Context, c *gozo.Conn, id int, e timedEvent) error {
+	var args []any
+	if err := json.Unmarshal([]byte(e.state.(invokeState)), &args); err != nil {
+		return err
+	}
+	_, err := c.EndpointInvokeCCAPI(ctx, id, a.endpoint, a.commandClass, a.method, args...)
+	return err
+}
@@ -0,0 +1,340 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dottedmag/gozo/internal/noderef"
+)
+
+type config struct {
+	ZWaveJSAPIEndpoint string       `toml:"zwavejs_api_endpoint"`
+	Timezone           string       `toml:"timezone"`
+	Latitude           *float64     `toml:"latitude"`   // for sunrise and sunset events
+	Longitude          *float64     `toml:"longitude"`  // east is positive
+	StateFile          string       `toml:"state_file"` // relative to the config file
+	Nodes              []configNode `toml:"node"`
+}
+
+type configNode struct {
+	ID           int
+	Name         string // "location/name" or just name, instead of ID
+	Description  string
+	Schedule     []configScheduleEvent
+	Periods      []configPeriod `toml:"period"`
+	Override     string         // "next-event" (default), "revert" or duration to hold manual changes for
+	Actuator     string         // "binary-switch", "multilevel-switch", "thermostat" or "invoke"
+	CommandClass int            `toml:"command_class"` // 0x25 Binary Switch or 0x26 Multilevel Switch instead of actuator, or CC to invoke
+	Endpoint     *int           // 0 by default, 1 for thermostats
+	Method       string         // CC API method to invoke
+	OnMode       any            `toml:"on_mode"`  // thermostat mode for on=true, "heat" by default
+	OffMode      any            `toml:"off_mode"` // thermostat mode for on=false, "off" by default
+}
+
+type configPeriod struct {
+	From     string // "01-02" for every year or "2006-01-02"
+	Until    string // inclusive
+	Schedule []configScheduleEvent
+}
+
+type configScheduleEvent struct {
+	At           string // "15:04:05", or "sunrise"/"sunset" with optional offset: "sunset-00:30", "sunrise+15m"
+	On           bool
+	Level        *int     // 0-99, for Multilevel Switch nodes, instead of on
+	Duration     string   // of transition, "5s"
+	Mode         any      // "off", "heat", "cool", "auto", "eco" or a Thermostat Mode CC number, instead of on
+	Setpoint     any      // °C; the thermostat mode is kept if neither on nor mode is set
+	SetpointType string   `toml:"setpoint_type"` // "heating" (default), "cooling" or "eco"
+	Args         []any    // of CC API method to invoke
+	Days         []string // "mon".."sun", "weekdays", "weekend"; every day if empty
+}
+
+// parseConfig returns nodes keyed by node reference, see gozo.Conn.ResolveNode.
+// Nodes without actuator and command_class use defaultActuator.
+func parseConfig(c config, defaultActuator string) (*time.Location, map[string]node, error) {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load timezone %q: %v", c.Timezone, err)
+	}
+
+	var p *place
+	switch {
+	case c.Latitude != nil && c.Longitude != nil:
+		if *c.Latitude < -90 || *c.Latitude > 90 || *c.Longitude < -180 || *c.Longitude > 180 {
+			return nil, nil, fmt.Errorf("coordinates %v, %v are out of range", *c.Latitude, *c.Longitude)
+		}
+		p = &place{lat: *c.Latitude, lon: *c.Longitude}
+	case c.Latitude != nil || c.Longitude != nil:
+		return nil, nil, fmt.Errorf("both latitude and longitude are needed")
+	}
+
+	out := map[string]node{}
+
+	for _, cn := range c.Nodes {
+		ref, err := noderef.Ref(cn.ID, cn.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := out[ref]; ok {
+			return nil, nil, fmt.Errorf("node %s is present multiple times in config", ref)
+		}
+
+		act, err := parseActuator(cn, defaultActuator)
+		if err != nil {
+			return nil, nil, fmt.Errorf("node %s: %v", ref, err)
+		}
+
+		events, err := parseSchedule(cn.Schedule, act)
+		if err != nil {
+			return nil, nil, fmt.Errorf("node %s: %v", ref, err)
+		}
+
+		var periods []period
+		for _, cp := range cn.Periods {
+			pr, err := parsePeriod(cp, act)
+			if err != nil {
+				return nil, nil, fmt.Errorf("node %s: %v", ref, err)
+			}
+			periods = append(periods, pr)
+		}
+
+		override, err := parseOverride(cn.Override)
+		if err != nil {
+			return nil, nil, fmt.Errorf("node %s: %v", ref, err)
+		}
+
+		n := node{
+			description: cn.Description,
+			actuator:    act,
+			schedule:    events,
+			periods:     periods,
+			override:    override,
+		}
+		if n.usesSun() {
+			if p == nil {
+				return nil, nil, fmt.Errorf("node %s: sunrise and sunset events need latitude and longitude", ref)
+			}
+			n.place = *p
+		}
+		out[ref] = n
+	}
+
+	return loc, out, nil
+}
+
+func parseActuator(cn configNode, defaultActuator string) (actuator, error) {
+	kind := cn.Actuator
+	if kind == "" {
+		// Configs predating actuators select switches by command class
+		switch cn.CommandClass {
+		case 0:
+			kind = defaultActuator
+		case binarySwitch:
+			kind = "binary-switch"
+		case multilevelSwitch:
+			kind = "multilevel-switch"
+		default:
+			return nil, fmt.Errorf("unsupported command class 0x%x", cn.CommandClass)
+		}
+	} else if cn.CommandClass != 0 && kind != "invoke" {
+		return nil, fmt.Errorf("command_class is set for %s actuator", kind)
+	}
+	if kind != "invoke" && cn.Method != "" {
+		return nil, fmt.Errorf("method is set for %s actuator", kind)
+	}
+	if kind != "thermostat" && (cn.OnMode != nil || cn.OffMode != nil) {
+		return nil, fmt.Errorf("on_mode or off_mode is set for %s actuator", kind)
+	}
+
+	endpoint := 0
+	if kind == "thermostat" {
+		endpoint = 1
+	}
+	if cn.Endpoint != nil {
+		if *cn.Endpoint < 0 {
+			return nil, fmt.Errorf("invalid endpoint %d", *cn.Endpoint)
+		}
+		endpoint = *cn.Endpoint
+	}
+
+	switch kind {
+	case "binary-switch":
+		return switchActuator{commandClass: binarySwitch, endpoint: endpoint}, nil
+	case "multilevel-switch":
+		return switchActuator{commandClass: multilevelSwitch, endpoint: endpoint}, nil
+	case "thermostat":
+		a := thermostatActuator{endpoint: endpoint, onMode: modeNames["heat"], offMode: modeNames["off"]}
+		var err error
+		if cn.OnMode != nil {
+			if a.onMode, err = parseMode(cn.OnMode); err != nil {
+				return nil, fmt.Errorf("on_mode: %v", err)
+			}
+		}
+		if cn.OffMode != nil {
+			if a.offMode, err = parseMode(cn.OffMode); err != nil {
+				return nil, fmt.Errorf("off_mode: %v", err)
+			}
+		}
+		return a, nil
+	case "invoke":
+		if cn.CommandClass == 0 || cn.Method == "" {
+			return nil, fmt.Errorf("invoke actuator needs command_class and method")
+		}
+		return invokeActuator{commandClass: cn.CommandClass, endpoint: endpoint, method: cn.Method}, nil
+	default:
+		return nil, fmt.Errorf("unknown actuator %q", kind)
+	}
+}
+
+func parseSchedule(cevs []configScheduleEvent, act actuator) ([]scheduleEvent, error) {
+	var events []scheduleEvent
+	for _, cev := range cevs {
+		event, err := parseAt(cev.At)
+		if err != nil {
+			return nil, err
+		}
+		event.days, err = parseWeekdays(cev.Days)
+		if err != nil {
+			return nil, fmt.Errorf("event at %s: %v", cev.At, err)
+		}
+		event.state, err = act.parseState(cev)
+		if err != nil {
+			return nil, fmt.Errorf("event at %s: %v", cev.At, err)
+		}
+		if cev.Duration != "" {
+			// Z-Wave durations are whole seconds, up to 127 minutes
+			d, err := time.ParseDuration(cev.Duration)
+			if err != nil || d < 0 || d > 127*time.Minute || d%time.Second != 0 {
+				return nil, fmt.Errorf("event at %s: invalid duration %q", cev.At, cev.Duration)
+			}
+			event.duration = d
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func parseAt(at string) (scheduleEvent, error) {
+	var e scheduleEvent
+	var offset string
+	switch {
+	case strings.HasPrefix(at, "sunrise"):
+		e.sun, offset = sunrise, strings.TrimPrefix(at, "sunrise")
+	case strings.HasPrefix(at, "sunset"):
+		e.sun, offset = sunset, strings.TrimPrefix(at, "sunset")
+	default:
+		t, err := time.Parse("15:04:05", at)
+		if err != nil {
+			return e, fmt.Errorf("failed to parse time %q: %v", at, err)
+		}
+		e.hour, e.min, e.sec = t.Hour(), t.Minute(), t.Second()
+		return e, nil
+	}
+
+	if offset == "" {
+		return e, nil
+	}
+	var sign time.Duration
+	switch offset[0] {
+	case '+':
+		sign = 1
+	case '-':
+		sign = -1
+	default:
+		return e, fmt.Errorf("failed to parse time %q: expected + or - after %s", at, strings.TrimSuffix(at, offset))
+	}
+	d, err := parseOffset(offset[1:])
+	if err != nil {
+		return e, fmt.Errorf("failed to parse time %q: %v", at, err)
+	}
+	e.offset = sign * d
+	return e, nil
+}
+
+// parseOffset parses "15:04", "15:04:05" or Go duration: "1h30m"
+func parseOffset(s string) (time.Duration, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid offset %q", s)
+	}
+	return d, nil
+}
+
+var weekdayNames = map[string]weekdays{
+	"sun":      1 << time.Sunday,
+	"mon":      1 << time.Monday,
+	"tue":      1 << time.Tuesday,
+	"wed":      1 << time.Wednesday,
+	"thu":      1 << time.Thursday,
+	"fri":      1 << time.Friday,
+	"sat":      1 << time.Saturday,
+	"weekdays": 1<<time.Monday | 1<<time.Tuesday | 1<<time.Wednesday | 1<<time.Thursday | 1<<time.Friday,
+	"weekend":  1<<time.Saturday | 1<<time.Sunday,
+}
+
+func parseWeekdays(names []string) (weekdays, error) {
+	var out weekdays
+	for _, name := range names {
+		w, ok := weekdayNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown day %q", name)
+		}
+		out |= w
+	}
+	return out, nil
+}
+
+func parsePeriod(cp configPeriod, act actuator) (period, error) {
+	from, err := parseDate(cp.From)
+	if err != nil {
+		return period{}, fmt.Errorf("period %s..%s: %v", cp.From, cp.Until, err)
+	}
+	until, err := parseDate(cp.Until)
+	if err != nil {
+		return period{}, fmt.Errorf("period %s..%s: %v", cp.From, cp.Until, err)
+	}
+	if (from.year == 0) != (until.year == 0) {
+		return period{}, fmt.Errorf("period %s..%s: both dates should either have a year or not", cp.From, cp.Until)
+	}
+	if from.year != 0 && from.ordinal() > until.ordinal() {
+		return period{}, fmt.Errorf("period %s..%s: ends before it starts", cp.From, cp.Until)
+	}
+
+	events, err := parseSchedule(cp.Schedule, act)
+	if err != nil {
+		return period{}, fmt.Errorf("period %s..%s: %v", cp.From, cp.Until, err)
+	}
+	return period{from: from, until: until, schedule: events}, nil
+}
+
+func parseDate(s string) (date, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return date{year: t.Year(), month: t.Month(), day: t.Day()}, nil
+	}
+	// Leap year, so that 02-29 is accepted
+	t, err := time.Parse("2006-01-02", "2000-"+s)
+	if err != nil {
+		return date{}, fmt.Errorf("failed to parse date %q", s)
+	}
+	return date{month: t.Month(), day: t.Day()}, nil
+}
+
+func parseOverride(s string) (overridePolicy, error) {
+	switch s {
+	case "", "next-event":
+		return overridePolicy{}, nil
+	case "revert":
+		return overridePolicy{revert: true}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return overridePolicy{}, fmt.Errorf("override should be next-event, revert or a positive duration, not %q", s)
+	}
+	return overridePolicy{hold: d}, nil
+}
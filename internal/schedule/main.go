@@ -0,0 +1,120 @@
+package schedule
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/internal/configfile"
+	"github.com/dottedmag/gozo/internal/noderef"
+)
+
+// Main runs a scheduler command with the given name. Nodes without an
+// actuator in config use defaultActuator.
+func Main(name, defaultActuator string) {
+	log.SetFlags(log.LUTC)
+
+	if len(os.Args) >= 2 && os.Args[1] == "plan" {
+		planMain(name, defaultActuator, os.Args[2:])
+		return
+	}
+
+	if len(os.Args) != 2 {
+		log.Printf("Usage: %s <config-file>", name)
+		log.Printf("       %s plan <config-file> [--from YYYY-MM-DD[THH:MM]] [--days N]", name)
+		os.Exit(2)
+	}
+
+	path := os.Args[1]
+	changes := configfile.Watch(path, 5*time.Second)
+
+	config, lc, err := loadConfig(path, defaultActuator)
+	if err != nil {
+		log.Printf("FATAL: Failed to load config file %s: %v", path, err)
+		os.Exit(1)
+	}
+
+	c, err := gozo.NewConn(config.ZWaveJSAPIEndpoint, func(m map[string]interface{}) {},
+		gozo.WithReconnectHandler(func() {
+			log.Printf("INFO: Reconnected to zwave-js API endpoint %s", config.ZWaveJSAPIEndpoint)
+		}))
+	if err != nil {
+		log.Printf("FATAL: Failed to connect to zwave-js API endpoint %s: %v", config.ZWaveJSAPIEndpoint, err)
+		os.Exit(1)
+	}
+
+	// Subscribe before resolving node names, so that no additions are missed
+	added, _ := c.Subscribe(gozo.EventFilter{
+		Source: gozo.SourceController,
+		Events: []string{gozo.EventNodeAdded},
+	})
+
+	resolved, err := noderef.Resolve(c, lc.nodes)
+	if err != nil {
+		log.Printf("FATAL: Failed to resolve nodes: %v", err)
+		os.Exit(1)
+	}
+
+	// Node additions might make unresolved names resolvable
+	wake := make(chan struct{}, 1)
+	go func() {
+		for range added {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	reload := make(chan loadedConfig)
+	go func() {
+		for range changes {
+			newConfig, lc, err := loadConfig(path, defaultActuator)
+			if err != nil {
+				log.Printf("ERR: Failed to load changed config file %s, keeping the previous one: %v", path, err)
+				continue
+			}
+			if newConfig.ZWaveJSAPIEndpoint != config.ZWaveJSAPIEndpoint {
+				log.Printf("ERR: Change of zwave-js API endpoint needs a restart, staying connected to %s", config.ZWaveJSAPIEndpoint)
+			}
+			if newConfig.StateFile != config.StateFile {
+				log.Printf("ERR: Change of state file needs a restart, keeping %q", config.StateFile)
+			}
+			reload <- lc
+		}
+	}()
+
+	s := &scheduler{
+		c:      c,
+		clock:  realClock{},
+		loc:    lc.loc,
+		nodes:  lc.nodes,
+		wake:   wake,
+		reload: reload,
+	}
+	if config.StateFile != "" {
+		s.stateFile = config.StateFile
+		if !filepath.IsAbs(s.stateFile) {
+			s.stateFile = filepath.Join(filepath.Dir(path), s.stateFile)
+		}
+	}
+	err = s.run(context.Background(), resolved)
+	log.Printf("FATAL: Lost connection to zwave-js API endpoint %s: %v", config.ZWaveJSAPIEndpoint, err)
+	os.Exit(1)
+}
+
+// loadConfig loads and validates the config file
+func loadConfig(path, defaultActuator string) (config, loadedConfig, error) {
+	var c config
+	if err := configfile.Load(path, &c); err != nil {
+		return c, loadedConfig{}, err
+	}
+	loc, nodes, err := parseConfig(c, defaultActuator)
+	if err != nil {
+		return c, loadedConfig{}, err
+	}
+	return c, loadedConfig{loc: loc, nodes: nodes}, nil
+}
@@ -0,0 +1,139 @@
+package schedule
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dottedmag/gozo/internal/cli"
+)
+
+// plan prints transitions of nodes for the days starting at from, as the
+// scheduler would make them
+func plan(w io.Writer, loc *time.Location, nodes map[string]node, from time.Time, days int) {
+	until := dayAt(from, days, loc)
+	until = time.Date(until.Year(), until.Month(), until.Day(), 0, 0, 0, 0, loc)
+
+	fmt.Fprintf(w, "Plan from %s until %s, %s\n", from.In(loc).Format(planLayout), until.Format(planLayout), loc)
+
+	for _, ref := range slices.Sorted(maps.Keys(nodes)) {
+		n := nodes[ref]
+		current := expectedState(n, from, loc)
+
+		fmt.Fprintf(w, "\nNode %s", ref)
+		if n.description != "" {
+			fmt.Fprintf(w, " (%s)", n.description)
+		}
+		fmt.Fprintf(w, ", %v at the start\n", current)
+
+		var events []timedEvent
+		for i := -1; ; i++ {
+			day := dayAt(from, i, loc)
+			if !day.Before(until.Add(24 * time.Hour)) {
+				break
+			}
+			for _, e := range n.eventsOn(day, loc) {
+				if e.at.After(from) && e.at.Before(until) {
+					events = append(events, e)
+				}
+			}
+		}
+		// Offsets might move events of a day into a neighbouring one
+		slices.SortStableFunc(events, func(a, b timedEvent) int {
+			return a.at.Compare(b.at)
+		})
+
+		if len(events) == 0 {
+			fmt.Fprintf(w, "  no events\n")
+		}
+		for i, e := range events {
+			var notes []string
+			if i+1 < len(events) && events[i+1].at.Equal(e.at) {
+				notes = append(notes, "overridden by the next event at the same second")
+			}
+			if note := dstNote(e.at.In(loc)); note != "" {
+				notes = append(notes, note)
+			}
+
+			what := e.state.String()
+			if current.satisfies(e.state) {
+				what += " (no change)"
+			}
+			if e.duration != 0 {
+				what += fmt.Sprintf(" over %v", e.duration)
+			}
+			current = current.apply(e.state)
+
+			line := fmt.Sprintf("  %-29s %s", e.at.In(loc).Format(planLayout), what)
+			if len(notes) != 0 {
+				line += "  ! " + strings.Join(notes, "; ")
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+const planLayout = "Mon 2006-01-02 15:04:05 MST"
+
+// dstNote tells if the time is affected by a DST transition
+func dstNote(t time.Time) string {
+	start, end := t.ZoneBounds()
+	_, offset := t.Zone()
+
+	if !start.IsZero() && t.Equal(start) {
+		if _, prev := start.Add(-time.Second).Zone(); prev < offset {
+			return "DST starts, times in the skipped hour are moved here"
+		}
+	}
+	if !end.IsZero() {
+		if _, next := end.Zone(); next < offset && end.Sub(t) <= time.Duration(offset-next)*time.Second {
+			return "DST ends, this time repeats, the event happens only at the first one"
+		}
+	}
+	return ""
+}
+
+// planMain is "plan" subcommand: it previews the schedule without
+// connecting to zwave-js
+func planMain(name, defaultActuator string, args []string) {
+	fs := flag.NewFlagSet(name+" plan", flag.ExitOnError)
+	fromFlag := fs.String("from", "", "start of the plan, YYYY-MM-DD or YYYY-MM-DDTHH:MM in the configured timezone (default now)")
+	days := fs.Int("days", 7, "number of days to plan")
+
+	positional, _ := cli.ParseFlags(fs, args) // exits on errors
+	if len(positional) != 1 || *days < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s plan <config-file> [--from YYYY-MM-DD[THH:MM]] [--days N]\n", name)
+		os.Exit(2)
+	}
+
+	_, lc, err := loadConfig(positional[0], defaultActuator)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config file %s: %v\n", positional[0], err)
+		os.Exit(1)
+	}
+
+	from := time.Now().In(lc.loc)
+	if *fromFlag != "" {
+		from, err = parseFrom(*fromFlag, lc.loc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	plan(os.Stdout, lc.loc, lc.nodes, from, *days)
+}
+
+func parseFrom(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("failed to parse --from %q: expected YYYY-MM-DD or YYYY-MM-DDTHH:MM", s)
+}
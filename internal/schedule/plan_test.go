@@ -0,0 +1,69 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlan(t *testing.T) {
+	loc, nodes, err := parseConfig(config{
+		Timezone: "Europe/Berlin",
+		Nodes: []configNode{
+			{ID: 2, Description: "night light", Schedule: []configScheduleEvent{
+				{At: "02:30:00", On: true},
+				{At: "06:00:00", On: false},
+				{At: "06:00:00", On: true, Days: []string{"sun"}},
+			}},
+			{ID: 3},
+		},
+	}, "binary-switch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		from     time.Time
+		expected string
+	}{
+		{
+			name: "DST starts",
+			from: time.Date(2026, 3, 29, 0, 0, 0, 0, loc),
+			expected: `Plan from Sun 2026-03-29 00:00:00 CET until Mon 2026-03-30 00:00:00 CEST, Europe/Berlin
+
+Node 2 (night light), off at the start
+  Sun 2026-03-29 03:00:00 CEST  on  ! DST starts, times in the skipped hour are moved here
+  Sun 2026-03-29 06:00:00 CEST  off  ! overridden by the next event at the same second
+  Sun 2026-03-29 06:00:00 CEST  on
+
+Node 3, unknown at the start
+  no events
+`,
+		},
+		{
+			name: "DST ends",
+			from: time.Date(2026, 10, 25, 0, 0, 0, 0, loc),
+			expected: `Plan from Sun 2026-10-25 00:00:00 CEST until Mon 2026-10-26 00:00:00 CET, Europe/Berlin
+
+Node 2 (night light), off at the start
+  Sun 2026-10-25 02:30:00 CEST  on  ! DST ends, this time repeats, the event happens only at the first one
+  Sun 2026-10-25 06:00:00 CET   off  ! overridden by the next event at the same second
+  Sun 2026-10-25 06:00:00 CET   on
+
+Node 3, unknown at the start
+  no events
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			plan(&out, loc, nodes, tt.from, 1)
+			if out.String() != tt.expected {
+				t.Errorf("plan is\n%s\nwant\n%s", out.String(), tt.expected)
+			}
+		})
+	}
+}
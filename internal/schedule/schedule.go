@@ -0,0 +1,305 @@
+// Package schedule puts Z-Wave nodes into states on schedule. It is the
+// engine of schedule and schedule-thermostat commands.
+package schedule
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/internal/health"
+)
+
+type node struct {
+	description string
+	actuator    actuator
+	place       place           // for sunrise and sunset events
+	schedule    []scheduleEvent // in config order
+	periods     []period        // replace schedule on their dates, first match wins
+	override    overridePolicy
+}
+
+// overridePolicy tells what to do if the node state is changed manually. By
+// default the manual state is held until the next event.
+type overridePolicy struct {
+	revert bool          // put the node back into scheduled state right away
+	hold   time.Duration // hold the manual state for a fixed time
+}
+
+// period is a date range with its own schedule, for seasons or holidays
+type period struct {
+	from, until date
+	schedule    []scheduleEvent // in config order
+}
+
+// date is a calendar date, recurring yearly if year is 0
+type date struct {
+	year  int
+	month time.Month
+	day   int
+}
+
+func (d date) ordinal() int {
+	return d.day + 100*(int(d.month)+100*d.year)
+}
+
+// contains checks if the local date of t is within the range, inclusive.
+// Yearly ranges may wrap around New Year.
+func (p period) contains(t time.Time) bool {
+	day := date{month: t.Month(), day: t.Day()}
+	if p.from.year != 0 {
+		day.year = t.Year()
+	}
+	if p.from.ordinal() <= p.until.ordinal() {
+		return p.from.ordinal() <= day.ordinal() && day.ordinal() <= p.until.ordinal()
+	}
+	return p.from.ordinal() <= day.ordinal() || day.ordinal() <= p.until.ordinal()
+}
+
+// weekdays is a set of days of week, empty set means every day
+type weekdays uint8
+
+func (w weekdays) has(d time.Weekday) bool {
+	return w == 0 || w&(1<<d) != 0
+}
+
+// sun is a moment of the day defined by the Sun
+type sun int
+
+const (
+	noSun sun = iota
+	sunrise
+	sunset
+)
+
+type scheduleEvent struct {
+	hour, min, sec int // unless sun is set
+	sun            sun
+	offset         time.Duration // from sunrise or sunset
+	days           weekdays
+	state          state
+	duration       time.Duration // of transition into the state
+}
+
+// timeOn returns the time of the event on the local date of t, if the event
+// happens on that date.
+//
+// On polar days sunrise events happen at the start of the day and sunset
+// events do not happen, and vice versa on polar nights.
+func (e scheduleEvent) timeOn(t time.Time, loc *time.Location, p place) (time.Time, bool) {
+	local := t.In(loc)
+	if e.sun == noSun {
+		return wallTime(local.Year(), local.Month(), local.Day(), e.hour, e.min, e.sec, loc), true
+	}
+
+	rise, set, pol := sunTimes(local.Year(), local.Month(), local.Day(), p)
+	switch {
+	case pol == polarDay && e.sun == sunrise, pol == polarNight && e.sun == sunset:
+		return wallTime(local.Year(), local.Month(), local.Day(), 0, 0, 0, loc), true
+	case pol != notPolar:
+		return time.Time{}, false
+	case e.sun == sunrise:
+		return rise.Add(e.offset), true
+	default:
+		return set.Add(e.offset), true
+	}
+}
+
+type timedEvent struct {
+	at       time.Time
+	state    state
+	duration time.Duration
+}
+
+// allEvents returns events of the node from all periods
+func (n node) allEvents() []scheduleEvent {
+	out := n.schedule
+	for _, p := range n.periods {
+		out = append(out[:len(out):len(out)], p.schedule...)
+	}
+	return out
+}
+
+func (n node) usesSun() bool {
+	for _, e := range n.allEvents() {
+		if e.sun != noSun {
+			return true
+		}
+	}
+	return false
+}
+
+// eventsOn returns events of the node happening on the local date of t,
+// sorted by time
+func (n node) eventsOn(t time.Time, loc *time.Location) []timedEvent {
+	local := t.In(loc)
+
+	schedule := n.schedule
+	for _, p := range n.periods {
+		if p.contains(local) {
+			schedule = p.schedule
+			break
+		}
+	}
+
+	var out []timedEvent
+	for _, e := range schedule {
+		if !e.days.has(local.Weekday()) {
+			continue
+		}
+		if at, ok := e.timeOn(local, loc, n.place); ok {
+			out = append(out, timedEvent{at: at, state: e.state, duration: e.duration})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].at.Before(out[j].at)
+	})
+	return out
+}
+
+// wallTime is time.Date that handles DST transitions predictably: the time
+// in a gap is moved to the end of the gap, and the earlier one is picked in
+// an overlap
+func wallTime(year int, month time.Month, day, hour, min, sec int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, min, sec, 0, loc)
+	want := time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+
+	wall := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	}
+
+	start, end := t.ZoneBounds()
+	switch {
+	case wall(t).After(want): // in a gap, moved past it
+		return start
+	case wall(t).Before(want): // in a gap, moved before it
+		return end
+	case start.IsZero():
+		return t
+	}
+
+	// Clocks were set back at the start of the zone, so the same wall time
+	// might have happened before it
+	_, prevOffset := start.Add(-time.Second).Zone()
+	_, offset := t.Zone()
+	if prevOffset > offset {
+		if earlier := t.Add(-time.Duration(prevOffset-offset) * time.Second); wall(earlier.In(loc)).Equal(want) {
+			return earlier
+		}
+	}
+	return t
+}
+
+// dayAt returns the noon of the local date of t shifted by the number of
+// days. Noon is never affected by DST transitions.
+func dayAt(t time.Time, days int, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day()+days, 12, 0, 0, 0, loc)
+}
+
+// Yearly periods repeat, so there is no point looking further back
+const maxLookbackDays = 366
+
+// expectedState returns the state set by the latest event before now. If
+// there are no events today before now, then it is the last one of the
+// previous day that has any events.
+func expectedState(n node, now time.Time, loc *time.Location) state {
+	return expectedEvent(n, now, loc).state
+}
+
+// expectedEvent returns the latest event before now, see expectedState
+func expectedEvent(n node, now time.Time, loc *time.Location) timedEvent {
+	var last *timedEvent
+	lastDay := 0
+	// Offsets might move events of a day into the next one, so the day before
+	// the one with the latest event is checked too
+	for i := 0; i <= maxLookbackDays && (last == nil || i <= lastDay+1); i++ {
+		for _, e := range n.eventsOn(dayAt(now, -i, loc), loc) {
+			if e.at.After(now) || last != nil && last.at.After(e.at) {
+				continue
+			}
+			if last == nil {
+				lastDay = i
+			}
+			last = &e
+		}
+	}
+
+	if last == nil {
+		return timedEvent{state: unknown}
+	}
+	return *last
+}
+
+// nextEvent returns the time of the first event after now, or zero time if
+// there are none
+func (n node) nextEvent(now time.Time, loc *time.Location) time.Time {
+	var next time.Time
+	nextDay := 0
+	// Offsets might move events of a day into the previous or the next one,
+	// so the days around the one with the earliest event are checked too
+	for i := -1; i <= maxLookbackDays && (next.IsZero() || i <= nextDay+1); i++ {
+		for _, e := range n.eventsOn(dayAt(now, i, loc), loc) {
+			if !e.at.After(now) || !next.IsZero() && !e.at.Before(next) {
+				continue
+			}
+			if next.IsZero() {
+				nextDay = i
+			}
+			next = e.at
+		}
+	}
+	return next
+}
+
+// nextTransition returns the time of the first event of any node after now,
+// or zero time if there are none
+func nextTransition(nodes map[int]node, now time.Time, loc *time.Location) time.Time {
+	var next time.Time
+	for _, n := range nodes {
+		if t := n.nextEvent(now, loc); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// transitionNodes puts nodes into states expected at the given time, and
+// records the states of transitioned nodes. Nodes that are not due according
+// to their health are skipped.
+func transitionNodes(ctx context.Context, c *gozo.Conn, nodes map[int]node, nodesCurrentStates map[int]state, h *health.Tracker, now time.Time, loc *time.Location) {
+	for id, node := range nodes {
+		// Nodes parked as dead stay parked even if they need no transition,
+		// not to be commanded at the next event
+		if !h.Due(id, now) {
+			continue
+		}
+		event := expectedEvent(node, now, loc)
+		expected := event.state
+		if expected == unknown || nodesCurrentStates[id].satisfies(expected) {
+			h.OK(id)
+			continue
+		}
+
+		err := node.actuator.setState(ctx, c, id, event)
+
+		// Offline nodes are likely to stay offline for a while, as they are
+		// probably just unplugged
+		if gozo.IsNodeDead(err) {
+			retry := h.Park(id, now)
+			log.Printf("INFO: Node %d (%s) is dead, failed to transition %v->%v, parked until alive or %v", id, node.description, nodesCurrentStates[id], expected, retry.In(loc))
+			continue
+		}
+		if err != nil {
+			retry := h.Failed(id, now)
+			log.Printf("ERR: Failed to transition %d (%s) %v->%v, retrying at %v: %v", id, node.description, nodesCurrentStates[id], expected, retry.In(loc), err)
+			continue
+		}
+
+		log.Printf("INFO: Transitioned %d (%s) %v->%v", id, node.description, nodesCurrentStates[id], expected)
+		nodesCurrentStates[id] = nodesCurrentStates[id].apply(expected)
+		h.OK(id)
+	}
+}
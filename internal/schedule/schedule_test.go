@@ -0,0 +1,501 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/gozotest"
+	"github.com/dottedmag/gozo/internal/health"
+)
+
+func TestExpectedState(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := node{
+		description: "test",
+		schedule: []scheduleEvent{
+			{hour: 6, min: 0, sec: 0, state: on},
+			{hour: 22, min: 0, sec: 0, state: off},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		expected state
+	}{
+		{
+			name:     "before first event",
+			now:      time.Date(2026, 3, 15, 5, 0, 0, 0, loc),
+			expected: off,
+		},
+		{
+			name:     "after first event",
+			now:      time.Date(2026, 3, 15, 12, 0, 0, 0, loc),
+			expected: on,
+		},
+		{
+			name:     "after second event",
+			now:      time.Date(2026, 3, 15, 23, 0, 0, 0, loc),
+			expected: off,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expectedState(n, tt.now, loc)
+			if got != tt.expected {
+				t.Errorf("expectedState at %v = %v, want %v", tt.now, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExpectedStateDays(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, nodes, err := parseConfig(config{
+		Timezone: "Europe/Berlin",
+		Nodes: []configNode{{
+			ID: 2,
+			Schedule: []configScheduleEvent{
+				{At: "07:00:00", On: true, Days: []string{"weekdays"}},
+				{At: "09:00:00", On: true, Days: []string{"weekend"}},
+				{At: "22:00:00", On: false},
+				{At: "23:30:00", On: true, Days: []string{"fri"}},
+			},
+			Periods: []configPeriod{
+				{From: "12-24", Until: "01-01", Schedule: []configScheduleEvent{
+					{At: "10:00:00", On: true},
+					{At: "23:00:00", On: false},
+				}},
+				{From: "2026-08-01", Until: "2026-08-14"}, // vacation, keep as is
+			},
+		}},
+	}, "binary-switch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := nodes["2"]
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		expected state
+	}{
+		{
+			name:     "weekday",
+			now:      time.Date(2026, 3, 16, 8, 0, 0, 0, loc),
+			expected: on,
+		},
+		{
+			name:     "weekend before its first event",
+			now:      time.Date(2026, 3, 15, 8, 0, 0, 0, loc),
+			expected: off,
+		},
+		{
+			name:     "monday before first event, after sunday",
+			now:      time.Date(2026, 3, 16, 5, 0, 0, 0, loc),
+			expected: off,
+		},
+		{
+			name:     "saturday before first event, after friday",
+			now:      time.Date(2026, 3, 14, 5, 0, 0, 0, loc),
+			expected: on,
+		},
+		{
+			name:     "holiday",
+			now:      time.Date(2026, 12, 25, 12, 0, 0, 0, loc),
+			expected: on,
+		},
+		{
+			name:     "holiday wrapping around new year",
+			now:      time.Date(2027, 1, 1, 9, 0, 0, 0, loc),
+			expected: off,
+		},
+		{
+			name:     "after holidays, before first event",
+			now:      time.Date(2027, 1, 2, 5, 0, 0, 0, loc),
+			expected: off,
+		},
+		{
+			name:     "vacation without events after friday",
+			now:      time.Date(2026, 8, 10, 12, 0, 0, 0, loc),
+			expected: on,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expectedState(n, tt.now, loc)
+			if got != tt.expected {
+				t.Errorf("expectedState at %v = %v, want %v", tt.now, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExpectedStateSun(t *testing.T) {
+	schedule := []configScheduleEvent{
+		{At: "sunrise+15m", On: false},
+		{At: "sunset-00:30", On: true},
+		{At: "23:00:00", On: false},
+	}
+	lat, lon := 52.52, 13.405
+
+	_, nodes, err := parseConfig(config{
+		Timezone:  "Europe/Berlin",
+		Latitude:  &lat,
+		Longitude: &lon,
+		Nodes:     []configNode{{ID: 2, Schedule: schedule}},
+	}, "binary-switch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin := nodes["2"]
+
+	tromso := berlin
+	tromso.place = place{lat: 69.65, lon: 18.96}
+
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		n        node
+		now      time.Time
+		expected state
+	}{
+		{
+			name:     "before sunrise",
+			n:        berlin,
+			now:      time.Date(2026, 6, 21, 4, 50, 0, 0, loc), // sunrise at 04:43
+			expected: off,
+		},
+		{
+			name:     "before sunset",
+			n:        berlin,
+			now:      time.Date(2026, 6, 21, 21, 0, 0, 0, loc), // sunset at 21:33
+			expected: off,
+		},
+		{
+			name:     "after sunset",
+			n:        berlin,
+			now:      time.Date(2026, 6, 21, 21, 10, 0, 0, loc),
+			expected: on,
+		},
+		{
+			name:     "after sunset, DST starts",
+			n:        berlin,
+			now:      time.Date(2026, 3, 29, 19, 10, 0, 0, loc), // sunset at 19:34 CEST
+			expected: on,
+		},
+		{
+			name:     "before sunset, DST starts",
+			n:        berlin,
+			now:      time.Date(2026, 3, 29, 19, 0, 0, 0, loc),
+			expected: off,
+		},
+		{
+			name:     "after sunset, DST ends",
+			n:        berlin,
+			now:      time.Date(2026, 10, 25, 16, 30, 0, 0, loc), // sunset at 16:50 CET
+			expected: on,
+		},
+		{
+			name:     "polar day",
+			n:        tromso,
+			now:      time.Date(2026, 6, 21, 12, 0, 0, 0, loc),
+			expected: off,
+		},
+		{
+			name:     "polar night",
+			n:        tromso,
+			now:      time.Date(2026, 12, 21, 12, 0, 0, 0, loc),
+			expected: on,
+		},
+		{
+			name:     "polar night, fixed time",
+			n:        tromso,
+			now:      time.Date(2026, 12, 21, 23, 30, 0, 0, loc),
+			expected: off,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expectedState(tt.n, tt.now, loc)
+			if got != tt.expected {
+				t.Errorf("expectedState at %v = %v, want %v", tt.now, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseAt(t *testing.T) {
+	tests := []struct {
+		at       string
+		expected scheduleEvent
+		err      bool
+	}{
+		{at: "06:20:00", expected: scheduleEvent{hour: 6, min: 20}},
+		{at: "sunset", expected: scheduleEvent{sun: sunset}},
+		{at: "sunset-00:30", expected: scheduleEvent{sun: sunset, offset: -30 * time.Minute}},
+		{at: "sunrise+15m", expected: scheduleEvent{sun: sunrise, offset: 15 * time.Minute}},
+		{at: "sunrise+01:00:30", expected: scheduleEvent{sun: sunrise, offset: time.Hour + 30*time.Second}},
+		{at: "sunrise15m", err: true},
+		{at: "sunrise+-15m", err: true},
+		{at: "noon", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAt(tt.at)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseAt(%q) = %+v, want error", tt.at, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.expected {
+			t.Errorf("parseAt(%q) = %+v, %v, want %+v", tt.at, got, err, tt.expected)
+		}
+	}
+}
+
+func TestTransitionNodes(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := []scheduleEvent{
+		{hour: 6, min: 0, sec: 0, state: on},
+		{hour: 22, min: 0, sec: 0, state: off},
+	}
+	nodes := map[int]node{
+		2: {description: "alive", actuator: switchActuator{commandClass: binarySwitch}, schedule: schedule},
+		3: {description: "dead", actuator: switchActuator{commandClass: binarySwitch}, schedule: schedule},
+	}
+	value := gozo.ValueID{CommandClass: 0x25, Property: "currentValue"}
+
+	srv := gozotest.NewServer(t,
+		gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 3, Status: gozo.NodeStatusDead})
+
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	states := map[int]state{2: unknown, 3: unknown}
+
+	h := health.New(time.Second, 24*time.Hour)
+	transitionNodes(ctx, c, nodes, states, h, time.Date(2026, 3, 15, 12, 0, 0, 0, loc), loc)
+	if !h.Healthy(2) || !h.Dead(3) {
+		t.Errorf("node 2 is healthy=%v, node 3 is dead=%v, want both", h.Healthy(2), h.Dead(3))
+	}
+	if states[2] != on || states[3] != unknown {
+		t.Errorf("states after transition are %v, want 2: on, 3: unknown", states)
+	}
+	if v, _ := srv.Value(2, value); v != true {
+		t.Errorf("value of node 2 is %#v, want true", v)
+	}
+
+	// Parked nodes are left alone until they are alive
+	srv.SetNodeStatus(3, gozo.NodeStatusAlive)
+	srv.ClearRequests()
+	transitionNodes(ctx, c, nodes, states, h, time.Date(2026, 3, 15, 23, 0, 0, 0, loc), loc)
+	for _, r := range srv.Requests() {
+		if r.Params["nodeId"] == 3.0 {
+			t.Errorf("parked node 3 got %s", r.Command)
+		}
+	}
+	if states[2] != off || states[3] != unknown {
+		t.Errorf("states after transition are %v, want 2: off, 3: unknown", states)
+	}
+
+	h.Alive(3)
+	transitionNodes(ctx, c, nodes, states, h, time.Date(2026, 3, 15, 23, 0, 0, 0, loc), loc)
+	if !h.Healthy(3) {
+		t.Errorf("node 3 is not healthy after transition")
+	}
+	if states[2] != off || states[3] != off {
+		t.Errorf("states after transition are %v, want off", states)
+	}
+	if v, _ := srv.Value(2, value); v != false {
+		t.Errorf("value of node 2 is %#v, want false", v)
+	}
+}
+
+func TestDimmer(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	level := 20
+	tooHigh := 100
+	_, nodes, err := parseConfig(config{
+		Timezone: "Europe/Berlin",
+		Nodes: []configNode{{
+			ID:           2,
+			CommandClass: 0x26,
+			Schedule: []configScheduleEvent{
+				{At: "06:00:00", On: true},
+				{At: "22:00:00", Level: &level, Duration: "5s"},
+				{At: "23:30:00", On: false},
+			},
+		}},
+	}, "binary-switch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cn := range []configNode{
+		{ID: 3, Schedule: []configScheduleEvent{{At: "22:00:00", Level: &level}}},
+		{ID: 3, CommandClass: 0x26, Schedule: []configScheduleEvent{{At: "22:00:00", Level: &tooHigh}}},
+		{ID: 3, CommandClass: 0x26, Schedule: []configScheduleEvent{{At: "22:00:00", Level: &level, On: true}}},
+		{ID: 3, CommandClass: 0x26, Schedule: []configScheduleEvent{{At: "22:00:00", Level: &level, Duration: "0.5s"}}},
+	} {
+		if _, _, err := parseConfig(config{Timezone: "Europe/Berlin", Nodes: []configNode{cn}}, "binary-switch"); err == nil {
+			t.Errorf("parseConfig of %+v succeeded, want error", cn)
+		}
+	}
+
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive})
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	n := map[int]node{2: nodes["2"]}
+	states := map[int]state{2: unknown}
+	value := nodes["2"].actuator.(switchActuator).value()
+
+	tests := []struct {
+		now      time.Time
+		expected any
+		state    state
+	}{
+		{now: time.Date(2026, 3, 15, 12, 0, 0, 0, loc), expected: 255.0, state: on},
+		{now: time.Date(2026, 3, 15, 22, 0, 0, 0, loc), expected: 20.0, state: levelState(20)},
+		{now: time.Date(2026, 3, 15, 23, 30, 0, 0, loc), expected: 0.0, state: off},
+	}
+	for _, tt := range tests {
+		h := health.New(time.Second, time.Minute)
+		transitionNodes(ctx, c, n, states, h, tt.now, loc)
+		if !h.Next().IsZero() {
+			t.Errorf("transition at %v failed", tt.now)
+		}
+		if v, _ := srv.Value(2, value); v != tt.expected || states[2] != tt.state {
+			t.Errorf("at %v node 2 has value %#v and state %v, want %v and %v", tt.now, v, states[2], tt.expected, tt.state)
+		}
+	}
+
+	var durations []any
+	for _, req := range srv.Requests() {
+		if args, _ := req.Params["args"].([]any); req.Command == "endpoint.invoke_cc_api" && len(args) > 1 {
+			durations = append(durations, args[1])
+		}
+	}
+	if len(durations) != 1 || durations[0] != "5s" {
+		t.Errorf("transition durations are %v, want only 5s", durations)
+	}
+
+	if !levelState(20).satisfies(on) || on.satisfies(levelState(20)) || levelState(20).satisfies(levelState(30)) {
+		t.Errorf("satisfies does not treat on as any level")
+	}
+}
+
+func TestActuators(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one := 1
+	_, nodes, err := parseConfig(config{
+		Timezone: "Europe/Berlin",
+		Nodes: []configNode{
+			{ID: 2},
+			{ID: 3, CommandClass: 0x26},
+			{ID: 4, Actuator: "thermostat"},
+			{ID: 5, Actuator: "multilevel-switch", Endpoint: &one},
+			{ID: 6, Actuator: "invoke", CommandClass: 0x70, Method: "set", Schedule: []configScheduleEvent{
+				{At: "06:00:00", Args: []any{map[string]any{"parameter": int64(5), "value": int64(1), "valueSize": int64(1)}}},
+				{At: "22:00:00", Args: []any{map[string]any{"parameter": int64(5), "value": int64(0), "valueSize": int64(1)}}},
+			}},
+		},
+	}, "thermostat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]actuator{
+		"2": thermostatActuator{endpoint: 1, onMode: 1, offMode: 0},
+		"3": switchActuator{commandClass: 0x26},
+		"4": thermostatActuator{endpoint: 1, onMode: 1, offMode: 0},
+		"5": switchActuator{commandClass: 0x26, endpoint: 1},
+		"6": invokeActuator{commandClass: 0x70, method: "set"},
+	}
+	for ref, a := range expected {
+		if nodes[ref].actuator != a {
+			t.Errorf("actuator of node %s is %+v, want %+v", ref, nodes[ref].actuator, a)
+		}
+	}
+
+	for _, cn := range []configNode{
+		{ID: 7, Actuator: "fan"},
+		{ID: 7, CommandClass: 0x40},
+		{ID: 7, Actuator: "thermostat", CommandClass: 0x25},
+		{ID: 7, Actuator: "invoke", CommandClass: 0x70},
+		{ID: 7, Actuator: "binary-switch", Method: "set"},
+		{ID: 7, Actuator: "binary-switch", OnMode: "heat"},
+		{ID: 7, Actuator: "invoke", CommandClass: 0x70, Method: "set", Schedule: []configScheduleEvent{{At: "06:00:00", On: true}}},
+	} {
+		if _, _, err := parseConfig(config{Timezone: "Europe/Berlin", Nodes: []configNode{cn}}, "binary-switch"); err == nil {
+			t.Errorf("parseConfig of %+v succeeded, want error", cn)
+		}
+	}
+
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 6, Status: gozo.NodeStatusAlive})
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	n := map[int]node{6: nodes["6"]}
+	states := map[int]state{6: unknown}
+	param := gozo.ValueID{CommandClass: 0x70, Property: 5}
+	for _, tt := range []struct {
+		now      time.Time
+		expected any
+	}{
+		{now: time.Date(2026, 3, 15, 12, 0, 0, 0, loc), expected: 1.0},
+		{now: time.Date(2026, 3, 15, 23, 0, 0, 0, loc), expected: 0.0},
+	} {
+		h := health.New(time.Second, time.Minute)
+		transitionNodes(context.Background(), c, n, states, h, tt.now, loc)
+		if !h.Next().IsZero() {
+			t.Errorf("transition at %v failed", tt.now)
+		}
+		if v, _ := srv.Value(6, param); v != tt.expected {
+			t.Errorf("at %v parameter 5 of node 6 is %#v, want %v", tt.now, v, tt.expected)
+		}
+	}
+}
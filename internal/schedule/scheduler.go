@@ -0,0 +1,374 @@
+package schedule
+
+import (
+	"context"
+	"log"
+	"maps"
+	"reflect"
+	"time"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/internal/configfile"
+	"github.com/dottedmag/gozo/internal/health"
+	"github.com/dottedmag/gozo/internal/noderef"
+)
+
+// clock is the source of time, replaceable in tests
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// scheduler transitions nodes exactly at the times of schedule events
+type scheduler struct {
+	c     *gozo.Conn
+	clock clock
+	loc   *time.Location
+	nodes map[string]node
+
+	// wake makes the scheduler recheck nodes before the next event
+	wake <-chan struct{}
+
+	// reload replaces the config, keeping the state of unchanged nodes
+	reload <-chan loadedConfig
+
+	// stateFile keeps commanded states and overrides across restarts, if set
+	stateFile string
+
+	resolved           map[int]node
+	nodesCurrentStates map[int]state
+	overrides          map[int]time.Time // manually overridden nodes, held until the time
+	settling           map[int]time.Time // nodes in transition, until the time
+	commanded          map[int]command   // last commands to nodes
+	health             *health.Tracker   // of nodes that failed to transition
+	saved              map[int]record    // from the state file, not yet picked up by nodes
+	lastSaved          map[int]record
+}
+
+// Nodes keep reporting intermediate states for a while after a command, even
+// without a transition duration, e.g. dimmers moving to the level at their
+// default rate
+const minSettling = 30 * time.Second
+
+type command struct {
+	state state
+	at    time.Time
+}
+
+// run services the nodes until the context is done or the connection is
+// closed
+func (s *scheduler) run(ctx context.Context, resolved map[int]node) error {
+	events, unsubscribe := s.c.Subscribe(gozo.EventFilter{
+		Source: gozo.SourceNode,
+		Events: []string{gozo.EventValueUpdated, gozo.EventAlive, gozo.EventWakeUp, gozo.EventDead},
+	})
+	defer unsubscribe()
+
+	s.nodesCurrentStates = map[int]state{}
+	s.overrides = map[int]time.Time{}
+	s.settling = map[int]time.Time{}
+	s.commanded = map[int]command{}
+	s.health = health.New(health.DefaultMin, health.DefaultMax)
+	if s.stateFile != "" {
+		saved, err := loadStateFile(s.stateFile)
+		if err != nil {
+			log.Printf("ERR: Failed to load state file %s, starting afresh: %v", s.stateFile, err)
+		}
+		s.saved = saved
+	}
+	s.setResolved(resolved)
+
+	for {
+		now := s.clock.Now()
+
+		active := map[int]node{}
+		for id, node := range s.resolved {
+			if until, ok := s.overrides[id]; ok {
+				if now.Before(until) {
+					s.health.Forget(id) // not to be retried while held
+					continue
+				}
+				log.Printf("INFO: Node %d (%s) is back on schedule", id, node.description)
+				delete(s.overrides, id)
+			}
+			active[id] = node
+		}
+
+		prevStates := maps.Clone(s.nodesCurrentStates)
+		transitionNodes(ctx, s.c, active, s.nodesCurrentStates, s.health, now, s.loc)
+
+		for id, node := range active {
+			if s.nodesCurrentStates[id] != prevStates[id] {
+				s.commanded[id] = command{state: s.nodesCurrentStates[id], at: now}
+				s.settling[id] = now.Add(minSettling + expectedEvent(node, now, s.loc).duration)
+			}
+		}
+		s.saveState()
+
+		var delay time.Duration
+		if retry := s.health.Next(); !retry.IsZero() {
+			delay = retry.Sub(now)
+		}
+		next := nextTransition(active, now, s.loc)
+		for _, until := range s.overrides {
+			if next.IsZero() || until.Before(next) {
+				next = until
+			}
+		}
+		if !next.IsZero() && (delay == 0 || next.Sub(now) < delay) {
+			delay = next.Sub(now)
+			log.Printf("INFO: Next event at %v", next.In(s.loc))
+		}
+
+		var timer <-chan time.Time // no events at all: wait to be woken up
+		if delay != 0 {
+			timer = s.clock.After(delay)
+		}
+
+	wait:
+		for {
+			select {
+			case <-timer:
+				break wait
+			case <-s.wake:
+				break wait
+			case lc := <-s.reload:
+				s.setConfig(lc)
+				break wait
+			case ev, ok := <-events:
+				if !ok {
+					<-s.c.Done()
+					return s.c.Err()
+				}
+				if s.handleEvent(ev) {
+					break wait
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.c.Done():
+				return s.c.Err()
+			}
+		}
+
+		// Named nodes might have been re-included under a different ID
+		r, err := noderef.Resolve(s.c, s.nodes)
+		if err != nil {
+			log.Printf("ERR: Failed to resolve nodes, keeping the previous ones: %v", err)
+			continue
+		}
+		s.setResolved(r)
+	}
+}
+
+// loadedConfig is a parsed config
+type loadedConfig struct {
+	loc   *time.Location
+	nodes map[string]node
+}
+
+// setConfig switches to the reloaded config, unless its nodes cannot be
+// resolved
+func (s *scheduler) setConfig(lc loadedConfig) {
+	resolved, err := noderef.Resolve(s.c, lc.nodes)
+	if err != nil {
+		log.Printf("ERR: Failed to resolve nodes of reloaded config, keeping the previous one: %v", err)
+		return
+	}
+
+	added, removed, changed := configfile.Diff(s.nodes, lc.nodes)
+	log.Printf("INFO: Reloaded config: added nodes %v, removed nodes %v, changed nodes %v", added, removed, changed)
+
+	s.loc = lc.loc
+	s.nodes = lc.nodes
+	s.setResolved(resolved)
+}
+
+func (s *scheduler) setResolved(resolved map[int]node) {
+	for id, node := range s.resolved {
+		if _, ok := resolved[id]; !ok {
+			log.Printf("INFO: No longer servicing node %d (%s)", id, node.description)
+			delete(s.nodesCurrentStates, id)
+			delete(s.overrides, id)
+			delete(s.settling, id)
+			delete(s.commanded, id)
+			s.health.Forget(id)
+		}
+	}
+	prev := s.resolved
+	s.resolved = resolved
+
+	for id, node := range resolved {
+		old, ok := prev[id]
+		switch {
+		case ok && reflect.DeepEqual(old, node):
+			continue
+		case ok:
+			// Overrides were held against the old schedule, and the
+			// state might be of another actuator
+			log.Printf("INFO: Node %d (%s) has changed in config", id, node.description)
+			delete(s.overrides, id)
+			delete(s.settling, id)
+			delete(s.commanded, id)
+		default:
+			log.Printf("INFO: Servicing node %d (%s)", id, node.description)
+		}
+
+		// Avoid sending a command if the node is already in the expected
+		// state. Otherwise, without a saved state, there is no telling
+		// whether the state is a manual override or a missed transition, so
+		// the schedule wins.
+		s.nodesCurrentStates[id] = node.actuator.currentState(s.c, id)
+		if r, ok := s.saved[id]; ok {
+			delete(s.saved, id)
+			s.restore(id, node, r)
+		}
+	}
+}
+
+// restore picks up the state of the node saved before restart
+func (s *scheduler) restore(id int, node node, r record) {
+	now := s.clock.Now()
+	if now.Before(r.Override) {
+		log.Printf("INFO: Holding manual state of node %d (%s) until %v, as before restart", id, node.description, r.Override.In(s.loc))
+		s.overrides[id] = r.Override
+	}
+
+	commanded, ok := decodeState(node.actuator, r.State)
+	if !ok {
+		return
+	}
+	s.commanded[id] = command{state: commanded, at: r.At}
+
+	current := s.nodesCurrentStates[id]
+	if current == unknown {
+		s.nodesCurrentStates[id] = commanded // nothing tells otherwise
+		return
+	}
+	current = commanded.apply(current) // thermostats do not report which setpoint is in use
+	s.nodesCurrentStates[id] = current
+	if _, held := s.overrides[id]; held || current.satisfies(commanded) {
+		return
+	}
+
+	// The node is not in the commanded state. If there were no events since
+	// the command, then it was changed manually, otherwise a transition has
+	// been missed and is to be made.
+	if next := node.nextEvent(r.At, s.loc); !next.IsZero() && !next.After(now) {
+		return
+	}
+	log.Printf("INFO: Node %d (%s) was manually changed %v->%v while not running", id, node.description, commanded, current)
+	s.manualChange(id, node, current, now)
+}
+
+// saveState writes the state file, if it is configured and there are
+// changes
+func (s *scheduler) saveState() {
+	if s.stateFile == "" {
+		return
+	}
+
+	records := map[int]record{}
+	for id := range s.resolved {
+		cmd, commanded := s.commanded[id]
+		until, overridden := s.overrides[id]
+		if !commanded && !overridden {
+			continue
+		}
+		records[id] = record{State: encodeState(cmd.state), At: cmd.at, Override: until}
+	}
+	if reflect.DeepEqual(records, s.lastSaved) {
+		return
+	}
+
+	if err := saveStateFile(s.stateFile, records); err != nil {
+		log.Printf("ERR: Failed to save state file %s: %v", s.stateFile, err)
+		return
+	}
+	s.lastSaved = records
+}
+
+// handleEvent handles an event of a node. Returns true if nodes need to be
+// rechecked.
+func (s *scheduler) handleEvent(ev gozo.Event) bool {
+	node, ok := s.resolved[ev.Header().NodeID]
+	if !ok {
+		return false
+	}
+	id := ev.Header().NodeID
+
+	switch ev := ev.(type) {
+	case *gozo.ValueUpdatedEvent:
+		return s.handleUpdate(ev)
+	case *gozo.NodeDeadEvent:
+		retry := s.health.Park(id, s.clock.Now())
+		log.Printf("INFO: Node %d (%s) is dead, parked until alive or %v", id, node.description, retry.In(s.loc))
+		return true // to sleep until the right time
+	case *gozo.NodeAliveEvent, *gozo.NodeWakeUpEvent:
+		if !s.health.Alive(id) {
+			return false
+		}
+		log.Printf("INFO: Node %d (%s) is %s, retrying", id, node.description, ev.Header().Event)
+		return true
+	}
+	return false
+}
+
+// handleUpdate records a manual override of the node state, if the update is
+// one. Returns true if nodes need to be rechecked.
+func (s *scheduler) handleUpdate(ev *gozo.ValueUpdatedEvent) bool {
+	node, ok := s.resolved[ev.NodeID]
+	if !ok {
+		return false
+	}
+	newState, ok := node.actuator.updateState(s.nodesCurrentStates[ev.NodeID], ev.ValueID, ev.NewValue)
+	if !ok {
+		return false
+	}
+
+	now := s.clock.Now()
+	if now.Before(s.settling[ev.NodeID]) {
+		if newState.satisfies(s.commanded[ev.NodeID].state) {
+			s.nodesCurrentStates[ev.NodeID] = newState
+		}
+		return false // on the way to the commanded state
+	}
+	if newState.satisfies(s.nodesCurrentStates[ev.NodeID]) {
+		// Caused by the scheduler, but might tell more, e.g. the mode of a
+		// thermostat that only had its setpoint set
+		s.nodesCurrentStates[ev.NodeID] = newState
+		return false
+	}
+
+	log.Printf("INFO: Node %d (%s) was manually changed %v->%v", ev.NodeID, node.description, s.nodesCurrentStates[ev.NodeID], newState)
+	s.manualChange(ev.NodeID, node, newState, now)
+	return true
+}
+
+// manualChange applies the override policy of the node to its manual change
+func (s *scheduler) manualChange(id int, node node, newState state, now time.Time) {
+	s.nodesCurrentStates[id] = newState
+
+	var until time.Time
+	switch {
+	case node.override.revert:
+		log.Printf("INFO: Reverting manual change of node %d (%s)", id, node.description)
+		return
+	case newState.satisfies(expectedState(node, now, s.loc)):
+		delete(s.overrides, id) // back to schedule by hand
+		return
+	case node.override.hold != 0:
+		until = now.Add(node.override.hold)
+	default:
+		until = node.nextEvent(now, s.loc)
+		if until.IsZero() {
+			return // no more events, there is nothing to hold for
+		}
+	}
+	log.Printf("INFO: Holding manual state of node %d (%s) until %v", id, node.description, until.In(s.loc))
+	s.overrides[id] = until
+}
@@ -0,0 +1,736 @@
+package schedule
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/gozotest"
+	"github.com/dottedmag/gozo/internal/health"
+	"github.com/dottedmag/gozo/internal/noderef"
+)
+
+func TestWallTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		got      time.Time
+		expected time.Time
+	}{
+		{
+			name:     "regular",
+			got:      wallTime(2026, 3, 28, 2, 30, 0, berlin),
+			expected: time.Date(2026, 3, 28, 1, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "gap, moved past it by time.Date",
+			got:      wallTime(2026, 3, 29, 2, 30, 0, berlin),
+			expected: time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "gap, moved before it by time.Date",
+			got:      wallTime(2026, 3, 8, 2, 30, 0, newYork),
+			expected: time.Date(2026, 3, 8, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "overlap, later picked by time.Date",
+			got:      wallTime(2026, 10, 25, 2, 30, 0, berlin),
+			expected: time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "overlap, earlier picked by time.Date",
+			got:      wallTime(2026, 11, 1, 1, 30, 0, newYork),
+			expected: time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.got.Equal(tt.expected) {
+				t.Errorf("wallTime = %v, want %v", tt.got.UTC(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestNextEvent(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := node{
+		schedule: []scheduleEvent{
+			{hour: 2, min: 30, sec: 0, state: on},
+			{hour: 22, min: 0, sec: 0, state: off},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		expected time.Time
+	}{
+		{
+			name:     "later today",
+			now:      time.Date(2026, 3, 28, 12, 0, 0, 0, loc),
+			expected: time.Date(2026, 3, 28, 22, 0, 0, 0, loc),
+		},
+		{
+			name:     "tomorrow, in DST gap",
+			now:      time.Date(2026, 3, 28, 23, 0, 0, 0, loc),
+			expected: time.Date(2026, 3, 29, 3, 0, 0, 0, loc),
+		},
+		{
+			name:     "in DST overlap, happens once",
+			now:      time.Date(2026, 10, 25, 0, 30, 0, 0, time.UTC), // 02:30 CEST
+			expected: time.Date(2026, 10, 25, 22, 0, 0, 0, loc),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := n.nextEvent(tt.now, loc)
+			if !got.Equal(tt.expected) {
+				t.Errorf("nextEvent after %v = %v, want %v", tt.now, got, tt.expected)
+			}
+		})
+	}
+}
+
+// fakeClock reports every wait to the test, and fires it once the test has
+// moved the time forward
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	next  time.Duration
+	waits chan time.Duration
+	fire  chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits <- d
+	return c.fire
+}
+
+// expectWait checks the wait requested after the scheduler has handled the
+// current time
+func (c *fakeClock) expectWait(t *testing.T, d time.Duration) {
+	t.Helper()
+	if got := <-c.waits; got != d {
+		t.Fatalf("scheduler waits for %v at %v, want %v", got, c.Now(), d)
+	}
+	c.next = d
+}
+
+// skip moves the time forward without firing the wait
+func (c *fakeClock) skip(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) advance() {
+	c.mu.Lock()
+	c.now = c.now.Add(c.next)
+	c.mu.Unlock()
+	c.fire <- c.Now()
+}
+
+func TestSchedulerRun(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := map[string]node{
+		"2": {actuator: switchActuator{commandClass: binarySwitch}, schedule: []scheduleEvent{
+			{hour: 6, min: 0, sec: 0, state: on},
+			{hour: 22, min: 0, sec: 0, state: off},
+		}},
+	}
+	value := gozo.ValueID{CommandClass: 0x25, Property: "currentValue"}
+
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive})
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	clock := &fakeClock{
+		now:   time.Date(2026, 3, 28, 5, 59, 0, 0, loc),
+		waits: make(chan time.Duration),
+		fire:  make(chan time.Time),
+	}
+	s := &scheduler{c: c, clock: clock, loc: loc, nodes: nodes}
+
+	resolved, err := noderef.Resolve(c, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.run(ctx, resolved)
+	}()
+
+	check := func(expected bool) {
+		t.Helper()
+		if v, _ := srv.Value(2, value); v != expected {
+			t.Errorf("value of node 2 at %v is %#v, want %v", clock.Now(), v, expected)
+		}
+	}
+
+	clock.expectWait(t, time.Minute)
+	check(false)
+	clock.advance()
+
+	clock.expectWait(t, 16*time.Hour)
+	check(true)
+	clock.advance()
+
+	clock.expectWait(t, 7*time.Hour) // DST starts, 22:00 CET to 06:00 CEST
+	check(false)
+	clock.advance()
+
+	clock.expectWait(t, 16*time.Hour)
+	check(true)
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("run returned %v, want context.Canceled", err)
+	}
+}
+
+func TestSchedulerHealth(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := map[string]node{
+		"2": {actuator: switchActuator{commandClass: binarySwitch}, schedule: []scheduleEvent{
+			{hour: 6, min: 0, sec: 0, state: on},
+			{hour: 22, min: 0, sec: 0, state: off},
+		}},
+	}
+	value := gozo.ValueID{CommandClass: 0x25, Property: "currentValue"}
+
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusDead})
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	clock := &fakeClock{
+		now:   time.Date(2026, 3, 26, 5, 59, 0, 0, loc),
+		waits: make(chan time.Duration),
+		fire:  make(chan time.Time),
+	}
+	s := &scheduler{c: c, clock: clock, loc: loc, nodes: nodes}
+
+	resolved, err := noderef.Resolve(c, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.run(ctx, resolved)
+	}()
+
+	// Dead node is parked, and is not retried at the next event
+	clock.expectWait(t, time.Minute)
+	clock.advance()
+	clock.expectWait(t, health.DefaultMax-time.Minute)
+
+	// and is retried once it is alive, without waiting
+	srv.SetNodeStatus(2, gozo.NodeStatusAlive)
+	clock.expectWait(t, 16*time.Hour)
+	if v, _ := srv.Value(2, value); v != true {
+		t.Errorf("value of node 2 is %#v, want true", v)
+	}
+	clock.advance()
+
+	// Failures back off
+	srv.Fail("endpoint.invoke_cc_api", &gozo.ServerError{ErrorCode: gozo.ErrorCodeUnknownError, Message: "boom"})
+	clock.expectWait(t, health.DefaultMin)
+	clock.advance()
+	clock.expectWait(t, 2*health.DefaultMin)
+	clock.advance()
+	clock.expectWait(t, 4*health.DefaultMin)
+
+	srv.Handle("endpoint.invoke_cc_api", nil)
+	clock.advance()
+	clock.expectWait(t, 8*time.Hour-70*time.Second)
+	if v, _ := srv.Value(2, value); v != false {
+		t.Errorf("value of node 2 is %#v, want false", v)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("run returned %v, want context.Canceled", err)
+	}
+}
+
+func TestSchedulerDeadEvent(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	act := switchActuator{commandClass: binarySwitch}
+	nodes := map[string]node{
+		"2": {actuator: act, schedule: []scheduleEvent{
+			{hour: 6, min: 0, sec: 0, state: on},
+			{hour: 6, min: 30, sec: 0, state: off},
+		}},
+	}
+
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive})
+	srv.SetValue(2, act.value(), true)
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	clock := &fakeClock{
+		now:   time.Date(2026, 3, 26, 6, 10, 0, 0, loc),
+		waits: make(chan time.Duration),
+		fire:  make(chan time.Time),
+	}
+	s := &scheduler{c: c, clock: clock, loc: loc, nodes: nodes}
+
+	resolved, err := noderef.Resolve(c, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.run(ctx, resolved)
+	}()
+
+	clock.expectWait(t, 20*time.Minute)
+
+	// Node in the scheduled state stays parked once it is reported dead, and
+	// is not commanded at the next event
+	srv.SetNodeStatus(2, gozo.NodeStatusDead)
+	clock.expectWait(t, 20*time.Minute)
+	clock.advance()
+	clock.expectWait(t, health.DefaultMax-20*time.Minute)
+	for _, req := range srv.Requests() {
+		if req.Command == "endpoint.invoke_cc_api" {
+			t.Errorf("dead node was commanded: %v", req.Params)
+		}
+	}
+
+	cancel()
+	<-done
+}
+
+func TestSchedulerOverride(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := []scheduleEvent{
+		{hour: 6, min: 0, sec: 0, state: on},
+		{hour: 22, min: 0, sec: 0, state: off},
+	}
+	act := switchActuator{commandClass: binarySwitch}
+	nodes := map[string]node{
+		"2": {description: "next event", actuator: act, schedule: schedule},
+		"3": {description: "revert", actuator: act, schedule: schedule, override: overridePolicy{revert: true}},
+		"4": {description: "hold", actuator: act, schedule: schedule, override: overridePolicy{hold: time.Hour}},
+	}
+	stateValue := act.value()
+
+	srv := gozotest.NewServer(t,
+		gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 3, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 4, Status: gozo.NodeStatusAlive})
+	// Nodes are in the scheduled state already, so manual changes are not
+	// taken for the settling of transitions
+	for id := 2; id <= 4; id++ {
+		srv.SetValue(id, stateValue, true)
+	}
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	clock := &fakeClock{
+		now:   time.Date(2026, 3, 28, 12, 0, 0, 0, loc),
+		waits: make(chan time.Duration),
+		fire:  make(chan time.Time),
+	}
+	s := &scheduler{c: c, clock: clock, loc: loc, nodes: nodes}
+
+	resolved, err := noderef.Resolve(c, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.run(ctx, resolved)
+	}()
+
+	check := func(expected map[int]bool) {
+		t.Helper()
+		for id, e := range expected {
+			if v, _ := srv.Value(id, stateValue); v != e {
+				t.Errorf("value of node %d at %v is %#v, want %v", id, clock.Now(), v, e)
+			}
+		}
+	}
+
+	clock.expectWait(t, 10*time.Hour)
+	check(map[int]bool{2: true, 3: true, 4: true})
+
+	srv.UpdateValue(2, stateValue, false)
+	clock.expectWait(t, 10*time.Hour)
+	check(map[int]bool{2: false})
+
+	srv.UpdateValue(3, stateValue, false)
+	clock.expectWait(t, 10*time.Hour)
+	check(map[int]bool{3: true})
+
+	srv.UpdateValue(4, stateValue, false)
+	clock.expectWait(t, time.Hour)
+	check(map[int]bool{4: false})
+	clock.advance()
+
+	clock.expectWait(t, 9*time.Hour)
+	check(map[int]bool{2: false, 3: true, 4: true})
+	clock.advance()
+
+	clock.expectWait(t, 7*time.Hour)
+	check(map[int]bool{2: false, 3: false, 4: false})
+
+	cancel()
+	<-done
+}
+
+func TestSchedulerSettling(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dimmer := switchActuator{commandClass: multilevelSwitch}
+	relay := switchActuator{commandClass: binarySwitch}
+	nodes := map[string]node{
+		"2": {description: "dimmer", actuator: dimmer, override: overridePolicy{revert: true}, schedule: []scheduleEvent{
+			{hour: 6, min: 0, sec: 0, state: levelState(50)},
+			{hour: 22, min: 0, sec: 0, state: off},
+		}},
+		"3": {description: "relay", actuator: relay, override: overridePolicy{hold: time.Hour}, schedule: []scheduleEvent{
+			{hour: 0, min: 0, sec: 0, state: on},
+		}},
+	}
+
+	srv := gozotest.NewServer(t,
+		gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 3, Status: gozo.NodeStatusAlive})
+	srv.SetValue(2, dimmer.value(), 0)
+	srv.SetValue(3, relay.value(), true)
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	clock := &fakeClock{
+		now:   time.Date(2026, 3, 28, 5, 59, 0, 0, loc),
+		waits: make(chan time.Duration),
+		fire:  make(chan time.Time),
+	}
+	s := &scheduler{c: c, clock: clock, loc: loc, nodes: nodes}
+
+	resolved, err := noderef.Resolve(c, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.run(ctx, resolved)
+	}()
+
+	clock.expectWait(t, time.Minute)
+	clock.advance()
+	clock.expectWait(t, 16*time.Hour)
+
+	// The dimmer moves to the level at its own rate, even though the command
+	// has no duration. The manual change of the relay, held for an hour, is
+	// handled after these reports.
+	srv.UpdateValue(2, dimmer.value(), 20)
+	srv.UpdateValue(2, dimmer.value(), 50)
+	srv.UpdateValue(3, relay.value(), false)
+	clock.expectWait(t, time.Hour)
+
+	// Changes past the settling are manual
+	clock.skip(minSettling)
+	srv.UpdateValue(2, dimmer.value(), 10)
+	clock.expectWait(t, time.Hour-minSettling)
+	if v, _ := srv.Value(2, dimmer.value()); v != float64(50) {
+		t.Errorf("value of node 2 is %#v, want 50", v)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestSchedulerReload(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	act := switchActuator{commandClass: binarySwitch}
+	schedule := []scheduleEvent{
+		{hour: 6, min: 0, sec: 0, state: on},
+		{hour: 22, min: 0, sec: 0, state: off},
+	}
+	nodes := map[string]node{
+		"2": {description: "unchanged", actuator: act, schedule: schedule},
+		"3": {description: "removed", actuator: act, schedule: schedule},
+		"4": {description: "changed", actuator: act, schedule: schedule},
+	}
+	stateValue := act.value()
+
+	srv := gozotest.NewServer(t,
+		gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 3, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 4, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 5, Status: gozo.NodeStatusAlive})
+	for id := 2; id <= 4; id++ {
+		srv.SetValue(id, stateValue, true)
+	}
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	clock := &fakeClock{
+		now:   time.Date(2026, 3, 28, 12, 0, 0, 0, loc),
+		waits: make(chan time.Duration),
+		fire:  make(chan time.Time),
+	}
+	reload := make(chan loadedConfig)
+	s := &scheduler{c: c, clock: clock, loc: loc, nodes: nodes, reload: reload}
+
+	resolved, err := noderef.Resolve(c, nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.run(ctx, resolved)
+	}()
+
+	check := func(expected map[int]any) {
+		t.Helper()
+		for id, e := range expected {
+			if v, _ := srv.Value(id, stateValue); v != e {
+				t.Errorf("value of node %d at %v is %#v, want %v", id, clock.Now(), v, e)
+			}
+		}
+	}
+
+	clock.expectWait(t, 10*time.Hour)
+	check(map[int]any{2: true, 3: true, 4: true})
+
+	// Manual overrides are held until 22:00
+	srv.UpdateValue(2, stateValue, false)
+	clock.expectWait(t, 10*time.Hour)
+	srv.UpdateValue(4, stateValue, false)
+	clock.expectWait(t, 10*time.Hour)
+
+	reload <- loadedConfig{loc: loc, nodes: map[string]node{
+		"2": nodes["2"],
+		"4": {description: "changed", actuator: act, schedule: schedule[:1]},
+		"5": {description: "added", actuator: act, schedule: schedule},
+	}}
+	clock.expectWait(t, 10*time.Hour)
+	check(map[int]any{2: false, 3: true, 4: true, 5: true})
+
+	// Invalid configs are not applied
+	reload <- loadedConfig{loc: loc, nodes: map[string]node{"missing": nodes["2"]}}
+	clock.expectWait(t, 10*time.Hour)
+	clock.advance()
+
+	clock.expectWait(t, 7*time.Hour) // DST starts
+	check(map[int]any{2: false, 3: true, 4: true, 5: false})
+
+	cancel()
+	<-done
+}
+
+func TestSchedulerRestart(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	act := switchActuator{commandClass: binarySwitch}
+	schedule := []scheduleEvent{
+		{hour: 6, min: 0, sec: 0, state: on},
+		{hour: 22, min: 0, sec: 0, state: off},
+	}
+	nodes := map[string]node{
+		"2": {description: "overridden", actuator: act, schedule: schedule},
+		"3": {description: "changed while stopped", actuator: act, schedule: schedule},
+		"4": {description: "missed transition", actuator: act, schedule: append(schedule, scheduleEvent{hour: 13, min: 30, sec: 0, state: on})},
+	}
+	stateValue := act.value()
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	srv := gozotest.NewServer(t,
+		gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 3, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 4, Status: gozo.NodeStatusAlive})
+
+	start := func(now time.Time) (*fakeClock, func()) {
+		c, err := gozo.NewConn(srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clock := &fakeClock{now: now, waits: make(chan time.Duration), fire: make(chan time.Time)}
+		s := &scheduler{c: c, clock: clock, loc: loc, nodes: nodes, stateFile: stateFile}
+		resolved, err := noderef.Resolve(c, nodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- s.run(ctx, resolved)
+		}()
+		return clock, func() {
+			cancel()
+			<-done
+			c.Close()
+		}
+	}
+	check := func(expected map[int]any) {
+		t.Helper()
+		for id, e := range expected {
+			if v, _ := srv.Value(id, stateValue); v != e {
+				t.Errorf("value of node %d is %#v, want %v", id, v, e)
+			}
+		}
+	}
+
+	clock, stop := start(time.Date(2026, 3, 28, 12, 0, 0, 0, loc))
+	clock.expectWait(t, 90*time.Minute)
+	check(map[int]any{2: true, 3: true, 4: true})
+	clock.skip(minSettling)
+	srv.UpdateValue(2, stateValue, false)
+	clock.expectWait(t, 90*time.Minute-minSettling)
+	stop()
+
+	srv.SetValue(3, stateValue, false)
+	srv.SetValue(4, stateValue, false)
+
+	clock, stop = start(time.Date(2026, 3, 28, 14, 0, 0, 0, loc))
+	clock.expectWait(t, 8*time.Hour)
+	check(map[int]any{2: false, 3: false, 4: true})
+	stop()
+
+	records, err := loadStateFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[2].Override.IsZero() || records[3].Override.IsZero() || !records[4].Override.IsZero() {
+		t.Errorf("state file has records %+v, want overrides of nodes 2 and 3", records)
+	}
+
+	// Stale records are dropped
+	delete(nodes, "4")
+	clock, stop = start(time.Date(2026, 3, 28, 14, 0, 0, 0, loc))
+	clock.expectWait(t, 8*time.Hour)
+	stop()
+	if records, _ := loadStateFile(stateFile); len(records) != 2 {
+		t.Errorf("state file has records %+v, want nodes 2 and 3", records)
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	if records, err := loadStateFile(path); err != nil || len(records) != 0 {
+		t.Errorf("loadStateFile of missing file = %v, %v, want no records", records, err)
+	}
+
+	at := time.Date(2026, 3, 28, 12, 0, 0, 0, time.UTC)
+	records := map[int]record{
+		2: {State: encodeState(levelState(20)), At: at},
+		3: {State: encodeState(thermostatState{mode: keepMode, setpointType: 1, setpoint: 21.5}), At: at, Override: at.Add(time.Hour)},
+		4: {State: encodeState(invokeState(`[{"parameter":3}]`)), At: at},
+	}
+	if err := saveStateFile(path, records); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadStateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id       int
+		actuator actuator
+		expected state
+	}{
+		{id: 2, actuator: switchActuator{commandClass: multilevelSwitch}, expected: levelState(20)},
+		{id: 3, actuator: thermostatActuator{}, expected: thermostatState{mode: keepMode, setpointType: 1, setpoint: 21.5}},
+		{id: 4, actuator: invokeActuator{}, expected: invokeState(`[{"parameter":3}]`)},
+		{id: 4, actuator: switchActuator{}},
+	}
+	for _, tt := range tests {
+		s, ok := decodeState(tt.actuator, loaded[tt.id].State)
+		if ok != (tt.expected != nil) || ok && s != tt.expected {
+			t.Errorf("decoded state of node %d for %T is %v, %v, want %v", tt.id, tt.actuator, s, ok, tt.expected)
+		}
+	}
+	if !loaded[3].Override.Equal(at.Add(time.Hour)) || !loaded[2].At.Equal(at) {
+		t.Errorf("loaded records %+v, want %+v", loaded, records)
+	}
+
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temporary files are left behind: %v", entries)
+	}
+}
@@ -0,0 +1,112 @@
+package schedule
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// record is what is kept about a node across restarts
+type record struct {
+	State    savedState `json:"state"`             // last commanded
+	At       time.Time  `json:"at"`                // of the last command
+	Override time.Time  `json:"override,omitzero"` // manual state is held until
+}
+
+// savedState is a state of one of the actuators
+type savedState struct {
+	Switch     string           `json:"switch,omitempty"`
+	Thermostat *savedThermostat `json:"thermostat,omitempty"`
+	Args       json.RawMessage  `json:"args,omitempty"`
+}
+
+type savedThermostat struct {
+	Mode         int     `json:"mode"`
+	SetpointType int     `json:"setpointType,omitempty"`
+	Setpoint     float64 `json:"setpoint,omitempty"`
+}
+
+func encodeState(s state) savedState {
+	switch s := s.(type) {
+	case switchState:
+		return savedState{Switch: string(s)}
+	case thermostatState:
+		return savedState{Thermostat: &savedThermostat{Mode: s.mode, SetpointType: s.setpointType, Setpoint: s.setpoint}}
+	case invokeState:
+		return savedState{Args: json.RawMessage(s)}
+	default:
+		return savedState{}
+	}
+}
+
+// decodeState returns false if the saved state is not of the actuator, e.g.
+// if the actuator has been changed in config
+func decodeState(a actuator, ss savedState) (state, bool) {
+	switch a.(type) {
+	case switchActuator:
+		return switchState(ss.Switch), ss.Switch != ""
+	case thermostatActuator:
+		if ss.Thermostat == nil {
+			return nil, false
+		}
+		return thermostatState{mode: ss.Thermostat.Mode, setpointType: ss.Thermostat.SetpointType, setpoint: ss.Thermostat.Setpoint}, true
+	case invokeActuator:
+		// Args are indented in the file
+		var buf bytes.Buffer
+		if ss.Args == nil || json.Compact(&buf, ss.Args) != nil {
+			return nil, false
+		}
+		return invokeState(buf.String()), true
+	default:
+		return nil, false
+	}
+}
+
+// loadStateFile returns records keyed by node ID. Missing file has no
+// records.
+func loadStateFile(path string) (map[int]record, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[int]record{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records map[int]record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// saveStateFile replaces the state file atomically, so that it is never
+// seen half-written
+func saveStateFile(path string, records map[int]record) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fh, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fh.Name()) // fails after the rename, as intended
+
+	if _, err := fh.Write(append(data, '\n')); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Sync(); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fh.Name(), path)
+}
@@ -0,0 +1,58 @@
+package schedule
+
+import (
+	"math"
+	"time"
+)
+
+// place is a point on Earth, in degrees, longitude positive to the east
+type place struct {
+	lat, lon float64
+}
+
+type polar int
+
+const (
+	notPolar   polar = iota
+	polarDay         // the Sun does not set
+	polarNight       // the Sun does not rise
+)
+
+var j2000 = time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func julianToTime(j float64) time.Time {
+	return j2000.Add(time.Duration((j - 2451545.0) * float64(24*time.Hour))).Round(time.Second)
+}
+
+func sinDeg(d float64) float64 { return math.Sin(d * math.Pi / 180) }
+func cosDeg(d float64) float64 { return math.Cos(d * math.Pi / 180) }
+
+// sunTimes computes sunrise and sunset for the date using the sunrise
+// equation, which is precise to a minute or so in inhabited latitudes.
+//
+// https://en.wikipedia.org/wiki/Sunrise_equation
+func sunTimes(year int, month time.Month, day int, p place) (rise, set time.Time, pol polar) {
+	// Days since J2000 to the mean solar noon at the place
+	days := math.Round(time.Date(year, month, day, 12, 0, 0, 0, time.UTC).Sub(j2000).Hours() / 24)
+	meanNoon := days - p.lon/360
+
+	anomaly := math.Mod(357.5291+0.98560028*meanNoon, 360)
+	center := 1.9148*sinDeg(anomaly) + 0.0200*sinDeg(2*anomaly) + 0.0003*sinDeg(3*anomaly)
+	eclipticLon := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := 2451545.0 + meanNoon + 0.0053*sinDeg(anomaly) - 0.0069*sinDeg(2*eclipticLon)
+
+	sinDecl := sinDeg(eclipticLon) * sinDeg(23.4397)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+
+	// -0.833° accounts for refraction and the size of the solar disc
+	cosHourAngle := (sinDeg(-0.833) - sinDeg(p.lat)*sinDecl) / (cosDeg(p.lat) * cosDecl)
+	switch {
+	case cosHourAngle < -1:
+		return time.Time{}, time.Time{}, polarDay
+	case cosHourAngle > 1:
+		return time.Time{}, time.Time{}, polarNight
+	}
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+
+	return julianToTime(transit - hourAngle/360), julianToTime(transit + hourAngle/360), notPolar
+}
@@ -0,0 +1,219 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/dottedmag/gozo"
+)
+
+const (
+	thermostatMode     = 0x40
+	thermostatSetpoint = 0x43
+)
+
+// keepMode is the mode of states that only change the setpoint
+const keepMode = -1
+
+var modeNames = map[string]int{
+	"off":  0,
+	"heat": 1,
+	"cool": 2,
+	"auto": 3,
+	"eco":  11, // energy save heat
+}
+
+var setpointTypes = map[string]int{
+	"heating": 1,
+	"cooling": 2,
+	"eco":     11, // energy save heating
+}
+
+func parseMode(v any) (int, error) {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 || v > 31 {
+			return 0, fmt.Errorf("mode %d is out of 0-31 range", v)
+		}
+		return int(v), nil
+	case string:
+		if m, ok := modeNames[v]; ok {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown mode %v", v)
+}
+
+func modeName(mode int) string {
+	for name, m := range modeNames {
+		if m == mode {
+			return name
+		}
+	}
+	return "mode " + strconv.Itoa(mode)
+}
+
+func setpointTypeName(t int) string {
+	for name, st := range setpointTypes {
+		if st == t {
+			return name
+		}
+	}
+	return "setpoint " + strconv.Itoa(t)
+}
+
+// thermostatActuator sets Thermostat Mode, and optionally a setpoint of
+// Thermostat Setpoint
+type thermostatActuator struct {
+	endpoint        int
+	onMode, offMode int // of on=true and on=false events
+}
+
+// thermostatState is the target of a thermostat
+type thermostatState struct {
+	mode         int     // or keepMode
+	setpointType int     // 0 if there is no setpoint
+	setpoint     float64 // °C
+}
+
+func (s thermostatState) String() string {
+	var out string
+	if s.mode != keepMode {
+		out = modeName(s.mode)
+	}
+	if s.setpointType != 0 {
+		if out != "" {
+			out += ", "
+		}
+		out += fmt.Sprintf("%s %g°C", setpointTypeName(s.setpointType), s.setpoint)
+	}
+	if out == "" {
+		return "unknown"
+	}
+	return out
+}
+
+func (s thermostatState) apply(target state) state {
+	t := target.(thermostatState)
+	if t.mode != keepMode {
+		s.mode = t.mode
+	}
+	if t.setpointType != 0 {
+		s.setpointType, s.setpoint = t.setpointType, t.setpoint
+	}
+	return s
+}
+
+func (s thermostatState) satisfies(target state) bool {
+	t, ok := target.(thermostatState)
+	if !ok || t.mode != keepMode && s.mode != t.mode {
+		return false
+	}
+	// Thermostats round setpoints to their precision
+	return t.setpointType == 0 || s.setpointType == t.setpointType && math.Abs(s.setpoint-t.setpoint) < 0.05
+}
+
+func (a thermostatActuator) parseState(cev configScheduleEvent) (state, error) {
+	if cev.Level != nil || cev.Duration != "" || cev.Args != nil {
+		return nil, fmt.Errorf("thermostats support only on, mode and setpoint")
+	}
+
+	var s thermostatState
+	switch {
+	case cev.Mode != nil && cev.On:
+		return nil, fmt.Errorf("both on and mode are set")
+	case cev.Mode != nil:
+		mode, err := parseMode(cev.Mode)
+		if err != nil {
+			return nil, err
+		}
+		s.mode = mode
+	case cev.On:
+		s.mode = a.onMode
+	case cev.Setpoint != nil:
+		s.mode = keepMode
+	default:
+		s.mode = a.offMode
+	}
+
+	if cev.Setpoint == nil {
+		if cev.SetpointType != "" {
+			return nil, fmt.Errorf("setpoint_type without setpoint")
+		}
+		return s, nil
+	}
+	s.setpointType = setpointTypes["heating"]
+	if cev.SetpointType != "" {
+		t, ok := setpointTypes[cev.SetpointType]
+		if !ok {
+			return nil, fmt.Errorf("unknown setpoint type %q", cev.SetpointType)
+		}
+		s.setpointType = t
+	}
+	switch sp := cev.Setpoint.(type) {
+	case float64:
+		s.setpoint = sp
+	case int64:
+		s.setpoint = float64(sp)
+	default:
+		return nil, fmt.Errorf("setpoint %v is not a number", cev.Setpoint)
+	}
+	return s, nil
+}
+
+func (a thermostatActuator) modeValue() gozo.ValueID {
+	return gozo.ValueID{CommandClass: thermostatMode, Endpoint: a.endpoint, Property: "mode"}
+}
+
+func (a thermostatActuator) setpointValue(setpointType int) gozo.ValueID {
+	return gozo.ValueID{CommandClass: thermostatSetpoint, Endpoint: a.endpoint, Property: "setpoint", PropertyKey: setpointType}
+}
+
+// currentState does not include the setpoint, as it is not known which one
+// is in use
+func (a thermostatActuator) currentState(c *gozo.Conn, id int) state {
+	if v, ok := c.Value(id, a.modeValue()); ok {
+		if mode, ok := v.(float64); ok {
+			return thermostatState{mode: int(mode)}
+		}
+	}
+	return unknown
+}
+
+func (a thermostatActuator) updateState(s state, vid gozo.ValueID, v any) (state, bool) {
+	f, ok := v.(float64)
+	if !ok || vid.CommandClass != thermostatMode && vid.CommandClass != thermostatSetpoint || vid.Endpoint != a.endpoint {
+		return s, false
+	}
+	ts, ok := s.(thermostatState)
+	if !ok {
+		ts = thermostatState{mode: keepMode}
+	}
+	switch {
+	case vid.Property == "mode":
+		ts.mode = int(f)
+		return ts, true
+	case vid.Property == "setpoint" && ts.setpointType != 0 && fmt.Sprint(vid.PropertyKey) == strconv.Itoa(ts.setpointType):
+		ts.setpoint = f
+		return ts, true
+	}
+	return s, false
+}
+
+func (a thermostatActuator) setState(ctx context.Context, c *gozo.Conn, id int, e timedEvent) error {
+	s := e.state.(thermostatState)
+	if s.mode != keepMode {
+		if _, err := c.EndpointInvokeCCAPI(ctx, id, a.endpoint, thermostatMode, "set", s.mode); err != nil {
+			return err
+		}
+	}
+	if s.setpointType != 0 {
+		const celsius = 0
+		if _, err := c.EndpointInvokeCCAPI(ctx, id, a.endpoint, thermostatSetpoint, "set", s.setpointType, s.setpoint, celsius); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/gozotest"
+	"github.com/dottedmag/gozo/internal/health"
+)
+
+func TestSetpoint(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comfort, eco := 21.5, int64(17)
+	endpoint := 0
+	_, nodes, err := parseConfig(config{
+		Timezone: "Europe/Berlin",
+		Nodes: []configNode{{
+			ID:       2,
+			Endpoint: &endpoint,
+			OffMode:  "eco",
+			Schedule: []configScheduleEvent{
+				{At: "06:00:00", On: true, Setpoint: comfort},
+				{At: "22:00:00", Setpoint: eco, SetpointType: "eco"},
+				{At: "23:00:00", On: false},
+				{At: "23:30:00", Mode: int64(2)},
+			},
+		}},
+	}, "thermostat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cn := range []configNode{
+		{ID: 3, OnMode: "warm"},
+		{ID: 3, Schedule: []configScheduleEvent{{At: "22:00:00", On: true, Mode: "heat"}}},
+		{ID: 3, Schedule: []configScheduleEvent{{At: "22:00:00", SetpointType: "eco"}}},
+		{ID: 3, Schedule: []configScheduleEvent{{At: "22:00:00", Setpoint: eco, SetpointType: "dry"}}},
+		{ID: 3, Schedule: []configScheduleEvent{{At: "22:00:00", On: true, Duration: "5s"}}},
+	} {
+		if _, _, err := parseConfig(config{Timezone: "Europe/Berlin", Nodes: []configNode{cn}}, "thermostat"); err == nil {
+			t.Errorf("parseConfig of %+v succeeded, want error", cn)
+		}
+	}
+
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive})
+	c, err := gozo.NewConn(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	n := map[int]node{2: nodes["2"]}
+	states := map[int]state{2: unknown}
+	act := nodes["2"].actuator.(thermostatActuator)
+
+	tests := []struct {
+		now      time.Time
+		mode     any
+		heating  any
+		eco      any
+		expected thermostatState
+	}{
+		{now: time.Date(2026, 3, 15, 12, 0, 0, 0, loc), mode: 1.0, heating: 21.5, expected: thermostatState{mode: 1, setpointType: 1, setpoint: 21.5}},
+		{now: time.Date(2026, 3, 15, 22, 0, 0, 0, loc), mode: 1.0, heating: 21.5, eco: 17.0, expected: thermostatState{mode: 1, setpointType: 11, setpoint: 17}},
+		{now: time.Date(2026, 3, 15, 23, 0, 0, 0, loc), mode: 11.0, heating: 21.5, eco: 17.0, expected: thermostatState{mode: 11, setpointType: 11, setpoint: 17}},
+		{now: time.Date(2026, 3, 15, 23, 30, 0, 0, loc), mode: 2.0, heating: 21.5, eco: 17.0, expected: thermostatState{mode: 2, setpointType: 11, setpoint: 17}},
+	}
+	for _, tt := range tests {
+		h := health.New(time.Second, time.Minute)
+		transitionNodes(ctx, c, n, states, h, tt.now, loc)
+		if !h.Next().IsZero() {
+			t.Errorf("transition at %v failed", tt.now)
+		}
+		mode, _ := srv.Value(2, act.modeValue())
+		heating, _ := srv.Value(2, act.setpointValue(1))
+		eco, _ := srv.Value(2, act.setpointValue(11))
+		if mode != tt.mode || heating != tt.heating || eco != tt.eco || states[2] != tt.expected {
+			t.Errorf("at %v node 2 has mode %#v, setpoints %#v/%#v and state %v, want %v, %v/%v and %v",
+				tt.now, mode, heating, eco, states[2], tt.mode, tt.heating, tt.eco, tt.expected)
+		}
+	}
+
+	if !(thermostatState{mode: 1, setpointType: 1, setpoint: 21.49}).satisfies(thermostatState{mode: keepMode, setpointType: 1, setpoint: 21.5}) {
+		t.Errorf("satisfies does not allow for setpoint precision")
+	}
+}
@@ -0,0 +1,300 @@
+package gozo
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// ValueMetadata is a subset of zwave-js value metadata
+type ValueMetadata struct {
+	Type             string            `json:"type"`
+	Readable         bool              `json:"readable"`
+	Writeable        bool              `json:"writeable"`
+	Label            string            `json:"label"`
+	Description      string            `json:"description"`
+	Min              *float64          `json:"min"`
+	Max              *float64          `json:"max"`
+	Default          any               `json:"default"`
+	Unit             string            `json:"unit"`
+	States           map[string]string `json:"states"`
+	ValueSize        int               `json:"valueSize"`
+	AllowManualEntry bool              `json:"allowManualEntry"`
+}
+
+// Value is a value of a node, as mirrored from zwave-js
+type Value struct {
+	ValueID
+	Value    any
+	Metadata ValueMetadata
+}
+
+type valueKey struct {
+	commandClass int
+	endpoint     int
+	property     string
+	propertyKey  string
+}
+
+// keyOf normalizes value IDs, so that properties decoded from JSON as float64
+// match the ones passed as int
+func keyOf(vid ValueID) valueKey {
+	k := valueKey{
+		commandClass: vid.CommandClass,
+		endpoint:     vid.Endpoint,
+		property:     fmt.Sprint(vid.Property),
+	}
+	if vid.PropertyKey != nil {
+		k.propertyKey = fmt.Sprint(vid.PropertyKey)
+	}
+	return k
+}
+
+type modelNode struct {
+	state  NodeState
+	values map[valueKey]*Value
+}
+
+// model mirrors the state of zwave-js driver. It is seeded from the result
+// of start_listening and kept up to date by events.
+type model struct {
+	mu         sync.Mutex
+	controller ControllerState
+	nodes      map[int]*modelNode
+}
+
+type valueDump struct {
+	ValueID
+	Value    any            `json:"value"`
+	Metadata *ValueMetadata `json:"metadata"`
+}
+
+type nodeDump struct {
+	NodeState
+	Values []valueDump `json:"values"`
+}
+
+func (n nodeDump) toModel() *modelNode {
+	mn := &modelNode{state: n.NodeState, values: map[valueKey]*Value{}}
+	for _, v := range n.Values {
+		val := &Value{ValueID: v.ValueID, Value: v.Value}
+		if v.Metadata != nil {
+			val.Metadata = *v.Metadata
+		}
+		mn.values[keyOf(v.ValueID)] = val
+	}
+	return mn
+}
+
+// seed replaces the model with the state from start_listening response
+func (m *model) seed(data []byte) error {
+	var resp struct {
+		Result struct {
+			State struct {
+				Controller ControllerState `json:"controller"`
+				Nodes      []nodeDump      `json:"nodes"`
+			} `json:"state"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.controller = resp.Result.State.Controller
+	m.nodes = map[int]*modelNode{}
+	for _, n := range resp.Result.State.Nodes {
+		m.nodes[n.NodeID] = n.toModel()
+	}
+	return nil
+}
+
+// apply updates the model from an event
+func (m *model) apply(data []byte) error {
+	var ev struct {
+		Source    string    `json:"source"`
+		Event     string    `json:"event"`
+		NodeID    int       `json:"nodeId"`
+		Node      *nodeDump `json:"node"`
+		NodeState *nodeDump `json:"nodeState"`
+		Args      struct {
+			ValueID
+			NewValue any            `json:"newValue"`
+			Metadata *ValueMetadata `json:"metadata"`
+		} `json:"args"`
+	}
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ev.Source == SourceController {
+		switch ev.Event {
+		case EventNodeAdded:
+			if ev.Node != nil {
+				m.nodes[ev.Node.NodeID] = ev.Node.toModel()
+			}
+		case EventNodeRemoved:
+			if ev.Node != nil {
+				delete(m.nodes, ev.Node.NodeID)
+			}
+		}
+		return nil
+	}
+
+	if ev.Source != SourceNode {
+		return nil
+	}
+
+	if ev.Event == EventReady && ev.NodeState != nil {
+		m.nodes[ev.NodeID] = ev.NodeState.toModel()
+		return nil
+	}
+
+	n := m.nodes[ev.NodeID]
+	if n == nil {
+		return nil
+	}
+
+	switch ev.Event {
+	case EventAlive:
+		n.state.Status = NodeStatusAlive
+	case EventDead:
+		n.state.Status = NodeStatusDead
+	case EventSleep:
+		n.state.Status = NodeStatusAsleep
+	case EventWakeUp:
+		n.state.Status = NodeStatusAwake
+	case EventValueAdded, EventValueUpdated:
+		k := keyOf(ev.Args.ValueID)
+		v := n.values[k]
+		if v == nil {
+			v = &Value{ValueID: ev.Args.ValueID}
+			n.values[k] = v
+		}
+		v.Value = ev.Args.NewValue
+		if ev.Args.Metadata != nil {
+			v.Metadata = *ev.Args.Metadata
+		}
+	case EventValueRemoved:
+		delete(n.values, keyOf(ev.Args.ValueID))
+	case EventMetadataUpdated:
+		k := keyOf(ev.Args.ValueID)
+		v := n.values[k]
+		if v == nil {
+			v = &Value{ValueID: ev.Args.ValueID}
+			n.values[k] = v
+		}
+		if ev.Args.Metadata != nil {
+			v.Metadata = *ev.Args.Metadata
+		}
+	}
+	return nil
+}
+
+// DriverState returns the current state of zwave-js driver, as mirrored from
+// zwave-js server
+func (c *Conn) DriverState() DriverState {
+	c.model.mu.Lock()
+	defer c.model.mu.Unlock()
+
+	st := DriverState{Controller: c.model.controller}
+	for _, id := range slices.Sorted(maps.Keys(c.model.nodes)) {
+		st.Nodes = append(st.Nodes, c.model.nodes[id].state)
+	}
+	return st
+}
+
+// Node returns the current state of the node
+func (c *Conn) Node(nodeID int) (NodeState, bool) {
+	c.model.mu.Lock()
+	defer c.model.mu.Unlock()
+
+	n := c.model.nodes[nodeID]
+	if n == nil {
+		return NodeState{}, false
+	}
+	return n.state, true
+}
+
+// Value returns the current value without a round trip to zwave-js server.
+// The value is decoded from JSON, so numbers are float64.
+func (c *Conn) Value(nodeID int, valueID ValueID) (any, bool) {
+	c.model.mu.Lock()
+	defer c.model.mu.Unlock()
+
+	n := c.model.nodes[nodeID]
+	if n == nil {
+		return nil, false
+	}
+	v := n.values[keyOf(valueID)]
+	if v == nil {
+		return nil, false
+	}
+	return v.Value, true
+}
+
+// Values returns all current values of the node
+func (c *Conn) Values(nodeID int) []Value {
+	c.model.mu.Lock()
+	defer c.model.mu.Unlock()
+
+	n := c.model.nodes[nodeID]
+	if n == nil {
+		return nil
+	}
+	var out []Value
+	for _, k := range slices.SortedFunc(maps.Keys(n.values), compareKeys) {
+		out = append(out, *n.values[k])
+	}
+	return out
+}
+
+func compareKeys(a, b valueKey) int {
+	return cmp.Or(
+		cmp.Compare(a.commandClass, b.commandClass),
+		cmp.Compare(a.endpoint, b.endpoint),
+		cmp.Compare(a.property, b.property),
+		cmp.Compare(a.propertyKey, b.propertyKey),
+	)
+}
+
+// ResolveNode finds the node by reference: a node ID, a node name, or
+// "location/name". Names are looked up in the mirrored driver state, so the
+// result follows nodes being re-included.
+func (c *Conn) ResolveNode(ref string) (int, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		return id, nil
+	}
+
+	c.model.mu.Lock()
+	defer c.model.mu.Unlock()
+
+	var ids []int
+	for id, n := range c.model.nodes {
+		if n.state.Name == "" {
+			continue
+		}
+		if ref == n.state.Name || ref == n.state.Location+"/"+n.state.Name {
+			ids = append(ids, id)
+		}
+	}
+
+	switch len(ids) {
+	case 0:
+		return 0, fmt.Errorf("node %q not found", ref)
+	case 1:
+		return ids[0], nil
+	default:
+		slices.Sort(ids)
+		return 0, fmt.Errorf("node %q is ambiguous: matches nodes %v", ref, ids)
+	}
+}
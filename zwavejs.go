@@ -1,9 +1,11 @@
 package gozo
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 
@@ -19,72 +21,386 @@ type request struct {
 	params  map[string]any
 }
 
+// DisconnectedError is returned by Call if the connection to zwave-js server
+// was lost before the response arrived, or while Conn is reconnecting.
+type DisconnectedError struct {
+	Err error
+}
+
+func (e *DisconnectedError) Error() string {
+	return fmt.Sprintf("disconnected from zwave-js server: %v", e.Err)
+}
+
+func (e *DisconnectedError) Unwrap() error {
+	return e.Err
+}
+
+var errReconnecting = errors.New("reconnecting")
+
+// ErrClosed is returned by calls on a closed Conn
+var ErrClosed = errors.New("connection is closed")
+
+// Range of zwave-js server API schema versions gozo supports
+const (
+	MinSchemaVersion = 14
+	MaxSchemaVersion = 40
+)
+
+// SchemaVersionError is returned by NewConn if the range of API schema
+// versions supported by zwave-js server does not overlap with the one of gozo
+type SchemaVersionError struct {
+	ServerMin, ServerMax int
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("zwave-js server supports API schema versions %d-%d, gozo supports %d-%d",
+		e.ServerMin, e.ServerMax, MinSchemaVersion, MaxSchemaVersion)
+}
+
+// Option configures Conn
+type Option func(*options)
+
+type options struct {
+	onReconnect func()
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	timeout     time.Duration
+	keepalive   time.Duration
+
+	onUnknownMessage func(data []byte, err error)
+}
+
+// WithTimeout sets the default timeout of Call, and of CallContext if the
+// context has no deadline. Zero timeout means waiting indefinitely.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithKeepalive sets how long zwave-js server may stay silent before the
+// connection is considered lost and is re-established. Conn pings the server
+// to keep it talking. Zero disables the check, leaving half-open connections
+// undetected.
+func WithKeepalive(d time.Duration) Option {
+	return func(o *options) {
+		o.keepalive = d
+	}
+}
+
+// WithReconnectHandler sets a function to be called every time Conn has
+// re-established the connection to zwave-js server. Events might have been
+// missed while the connection was down, so the state should be resynced.
+func WithReconnectHandler(f func()) Option {
+	return func(o *options) {
+		o.onReconnect = f
+	}
+}
+
+// WithUnknownMessageHandler sets a function to be called for messages from
+// zwave-js server Conn does not understand, instead of logging them
+func WithUnknownMessageHandler(f func(data []byte, err error)) Option {
+	return func(o *options) {
+		o.onUnknownMessage = f
+	}
+}
+
+func logUnknownMessage(data []byte, err error) {
+	log.Printf("gozo: ignoring message from zwave-js server: %v: %s", err, data)
+}
+
+// WithBackoff sets the delays between reconnection attempts. The delay starts
+// at min and doubles after every failed attempt, up to max.
+func WithBackoff(min, max time.Duration) Option {
+	return func(o *options) {
+		o.minBackoff = min
+		o.maxBackoff = max
+	}
+}
+
+// session is a single WebSocket connection to zwave-js server
+type session struct {
+	ws            *websocket.Conn
+	reqs          chan request
+	schemaVersion int
+	listenID      int           // messageId of start_listening or -1, guarded by Conn.mu
+	keepalive     time.Duration // of Conn, zero if disabled
+
+	once sync.Once
+	err  error // valid after done is closed
+	done chan struct{}
+}
+
+func (s *session) fail(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+		s.ws.Close()
+	})
+}
+
+// heard extends the read deadline once something is received from the
+// server, be it a message or a pong
+func (s *session) heard() error {
+	if s.keepalive == 0 {
+		return nil
+	}
+	return s.ws.SetReadDeadline(time.Now().Add(s.keepalive))
+}
+
+// Conn is a connection to zwave-js server. It stays usable across
+// reconnections until it is closed.
 type Conn struct {
-	c        *websocket.Conn
+	url  string
+	opts options
+
+	ctx    context.Context // canceled with the reason once Conn ends
+	cancel context.CancelCauseFunc
+	wg     sync.WaitGroup
+
 	mu       sync.Mutex
 	nextID   int
-	handlers map[int]chan<- map[string]any
+	handlers map[int]chan<- []byte
+	sess     *session // nil while reconnecting
 
-	eventHandler func(map[string]interface{})
+	schemaVersion int // as of the latest (re)connection
+	model         model
+
+	subscribers map[*subscriber]struct{}
+	closed      bool // subscribers are closed
 
-	reqs chan request
+	eventHandler func(map[string]interface{})
 }
 
-func NewConn(url string, eventHandler func(map[string]interface{})) (*Conn, error) {
-	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+// NewConn connects to zwave-js server. If the connection is lost later, Conn
+// reconnects in background. Calls made while the connection is down fail with
+// *DisconnectedError.
+//
+// eventHandler, if not nil, is called for every event, from the goroutine
+// reading the connection. Subscribe is a typed alternative.
+func NewConn(url string, eventHandler func(map[string]interface{}), opts ...Option) (*Conn, error) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	conn := &Conn{
+		ctx:    ctx,
+		cancel: cancel,
+		url:    url,
+		opts: options{
+			minBackoff: time.Second,
+			maxBackoff: time.Minute,
+			timeout:    10 * time.Second,
+			keepalive:  30 * time.Second,
+
+			onUnknownMessage: logUnknownMessage,
+		},
+		handlers:     map[int]chan<- []byte{},
+		model:        model{nodes: map[int]*modelNode{}},
+		subscribers:  map[*subscriber]struct{}{},
+		eventHandler: eventHandler,
+	}
+	for _, opt := range opts {
+		opt(&conn.opts)
+	}
+
+	s, err := conn.connect()
 	if err != nil {
+		cancel(err)
+		conn.wg.Wait()
 		return nil, err
 	}
+	conn.sess = s
+	conn.schemaVersion = s.schemaVersion
 
-	// Handshake
+	conn.wg.Add(1)
+	go conn.run(s)
 
-	var handshake struct {
-		MaxSchemaVersion int
-	}
-	_, data, err := c.ReadMessage()
+	return conn, nil
+}
+
+// Close closes the connection and waits for its goroutines to exit. Pending
+// and subsequent calls fail with ErrClosed, subscription channels are closed.
+//
+// Close must not be called from eventHandler or the reconnect handler.
+func (c *Conn) Close() error {
+	c.cancel(ErrClosed)
+	c.wg.Wait()
+	return nil
+}
+
+// Done returns a channel that is closed once Conn has ended, either due to
+// Close or a permanent error
+func (c *Conn) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Err returns the reason Conn has ended: ErrClosed, or a permanent error such
+// as *SchemaVersionError. Err returns nil while Conn is usable.
+func (c *Conn) Err() error {
+	return context.Cause(c.ctx)
+}
+
+func (c *Conn) connect() (*session, error) {
+	ws, _, err := websocket.DefaultDialer.DialContext(c.ctx, c.url, nil)
 	if err != nil {
 		return nil, err
 	}
-	if err := json.Unmarshal(data, &handshake); err != nil {
+
+	s := &session{
+		ws:        ws,
+		reqs:      make(chan request, 100),
+		listenID:  -1, // messageIds start at 0, the one of set_api_schema
+		keepalive: c.opts.keepalive,
+		done:      make(chan struct{}),
+	}
+
+	// Server that accepts the connection but never greets, e.g. one still
+	// starting up behind a proxy, fails the handshake by the deadline
+	if err := s.heard(); err != nil {
+		ws.Close()
 		return nil, err
 	}
+	ws.SetPongHandler(func(string) error { return s.heard() })
 
-	conn := &Conn{
-		c:            c,
-		handlers:     map[int]chan<- map[string]any{},
-		reqs:         make(chan request, 100),
-		eventHandler: eventHandler,
+	// Unblocks the handshake and the goroutines of the session once Conn ends
+	stop := context.AfterFunc(c.ctx, func() {
+		s.fail(context.Cause(c.ctx))
+	})
+
+	if err := c.handshake(s); err != nil {
+		stop()
+		s.fail(err)
+		return nil, err
 	}
 
+	c.wg.Add(1)
 	go func() {
-		must.OK(conn.runWrite())
-	}()
-	go func() {
-		must.OK(conn.runRead())
+		defer c.wg.Done()
+
+		<-s.done
+		stop()
 	}()
 
-	resp, err := conn.Call("set_api_schema", map[string]any{"schemaVersion": handshake.MaxSchemaVersion})
+	return s, nil
+}
+
+func (c *Conn) handshake(s *session) error {
+	ws := s.ws
+
+	var handshake struct {
+		Type             string
+		MinSchemaVersion int
+		MaxSchemaVersion int
+	}
+	_, data, err := ws.ReadMessage()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if resp["success"] == nil || !resp["success"].(bool) {
-		return nil, fmt.Errorf("failed to set API schema")
+	if err := json.Unmarshal(data, &handshake); err != nil {
+		return err
+	}
+	if handshake.Type != "version" {
+		return fmt.Errorf("unexpected handshake message type %q", handshake.Type)
 	}
 
-	resp, err = conn.Call("start_listening", nil)
-	if err != nil {
-		return nil, err
+	schemaVersion := min(handshake.MaxSchemaVersion, MaxSchemaVersion)
+	if schemaVersion < max(handshake.MinSchemaVersion, MinSchemaVersion) {
+		return &SchemaVersionError{ServerMin: handshake.MinSchemaVersion, ServerMax: handshake.MaxSchemaVersion}
+	}
+	s.schemaVersion = schemaVersion
+
+	c.wg.Add(2)
+	go c.runWrite(s)
+	go c.runRead(s)
+	if s.keepalive != 0 {
+		c.wg.Add(1)
+		go c.runPing(s)
+	}
+
+	ctx, cancel := c.withDefaultTimeout(context.Background())
+	defer cancel()
+
+	if err := c.command(ctx, s, "set_api_schema", map[string]any{"schemaVersion": schemaVersion}, nil); err != nil {
+		return fmt.Errorf("failed to set API schema: %w", err)
 	}
-	if resp["success"] == nil || !resp["success"].(bool) {
-		return nil, fmt.Errorf("failed to start listening to events")
+
+	// The result is used to seed the model by runRead
+	if err := c.command(ctx, s, "start_listening", nil, nil); err != nil {
+		return fmt.Errorf("failed to start listening to events: %w", err)
 	}
 
-	return conn, nil
+	return nil
 }
 
-func (c *Conn) runWrite() error {
+// run waits for the current session to fail and replaces it with a new one,
+// until Conn ends
+func (c *Conn) run(s *session) {
+	defer c.wg.Done()
+	defer c.closeSubscribers()
+
 	for {
-		req := <-c.reqs
+		<-s.done
+
+		c.mu.Lock()
+		c.sess = nil
+		c.mu.Unlock()
+
+		var err error
+		s, err = c.reconnect()
+		if err != nil {
+			c.cancel(err)
+			return
+		}
+
+		c.mu.Lock()
+		c.sess = s
+		c.schemaVersion = s.schemaVersion
+		c.mu.Unlock()
+
+		if c.opts.onReconnect != nil {
+			c.opts.onReconnect()
+		}
+	}
+}
+
+// reconnect returns an error only if Conn has ended, or if zwave-js server
+// has become incompatible
+func (c *Conn) reconnect() (*session, error) {
+	delay := c.opts.minBackoff
+	for {
+		select {
+		case <-time.After(delay):
+		case <-c.ctx.Done():
+			return nil, context.Cause(c.ctx)
+		}
+
+		s, err := c.connect()
+		if err == nil {
+			return s, nil
+		}
+
+		if err := context.Cause(c.ctx); err != nil {
+			return nil, err
+		}
+		var schemaErr *SchemaVersionError
+		if errors.As(err, &schemaErr) {
+			return nil, err
+		}
+
+		delay = min(2*delay, c.opts.maxBackoff)
+	}
+}
+
+func (c *Conn) runWrite(s *session) {
+	defer c.wg.Done()
+
+	for {
+		var req request
+		select {
+		case req = <-s.reqs:
+		case <-s.done:
+			return
+		}
+
 		bb := map[string]any{}
 		for k, v := range req.params {
 			bb[k] = v
@@ -94,77 +410,211 @@ func (c *Conn) runWrite() error {
 
 		data := must.OK1(json.Marshal(bb))
 
-		if err := c.c.WriteMessage(websocket.TextMessage, data); err != nil {
-			return err
+		if err := s.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+}
+
+// runPing pings the server, so that a half-open connection is detected by the
+// read deadline
+func (c *Conn) runPing(s *session) {
+	defer c.wg.Done()
+
+	t := time.NewTicker(s.keepalive / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-s.done:
+			return
+		}
+
+		if err := s.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.keepalive)); err != nil {
+			s.fail(err)
+			return
 		}
 	}
 }
 
-func (c *Conn) runRead() error {
+func (c *Conn) runRead(s *session) {
+	defer c.wg.Done()
+
 	for {
-		_, data, err := c.c.ReadMessage()
+		_, data, err := s.ws.ReadMessage()
+		if err == nil {
+			err = s.heard()
+		}
 		if err != nil {
-			return err
+			s.fail(err)
+			return
 		}
 
 		var msg struct {
 			Type      string
 			MessageID int
-			Event     map[string]interface{}
+			Event     json.RawMessage
 		}
 		if err := json.Unmarshal(data, &msg); err != nil {
-			return err
+			c.opts.onUnknownMessage(data, fmt.Errorf("malformed message: %w", err))
+			continue
 		}
 
 		switch msg.Type {
 		case "result":
 			c.mu.Lock()
 			resCh := c.handlers[msg.MessageID]
+			listening := msg.MessageID == s.listenID
 			c.mu.Unlock()
 
+			// Seeding the model here, before reading any further events,
+			// ensures no event is applied to a stale model
+			if listening {
+				if err := c.model.seed(data); err != nil {
+					c.opts.onUnknownMessage(data, fmt.Errorf("malformed driver state: %w", err))
+				}
+			}
+
 			if resCh == nil {
 				// Request timed out and the handler was removed
 				continue
 			}
 
 			//fmt.Printf("%s\n", data)
-			var resp map[string]any
-			must.OK(json.Unmarshal(data, &resp))
-			resCh <- resp
+			resCh <- data
 		case "event":
-			if c.eventHandler == nil {
-				panic(fmt.Errorf("event received with nil eventHandler"))
-			} else {
-				c.eventHandler(msg.Event)
+			ev, err := decodeEvent(msg.Event)
+			if err == nil {
+				err = c.model.apply(msg.Event)
+			}
+			if err != nil {
+				c.opts.onUnknownMessage(data, fmt.Errorf("malformed event: %w", err))
+				continue
+			}
+			c.dispatchEvent(ev)
+
+			if c.eventHandler != nil {
+				var event map[string]interface{}
+				must.OK(json.Unmarshal(msg.Event, &event))
+				c.eventHandler(event)
 			}
-			// ignored
 		default:
-			panic(fmt.Errorf("unexpected message %#v", msg))
+			// Newer schema versions might add message types
+			c.opts.onUnknownMessage(data, fmt.Errorf("unknown message type %q", msg.Type))
 		}
 	}
 }
 
+// SchemaVersion returns the API schema version negotiated with zwave-js
+// server during the latest (re)connection
+func (c *Conn) SchemaVersion() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.schemaVersion
+}
+
+// Call sends a command to zwave-js server and waits for the response, up to
+// the timeout set by WithTimeout.
+//
+// If the server fails to execute the command, Call returns *ServerError. If
+// the connection is down, or is lost while waiting, Call returns
+// *DisconnectedError. The command might or might not have been executed by
+// the server in the latter case.
 func (c *Conn) Call(command string, params map[string]any) (map[string]any, error) {
-	resCh := make(chan map[string]any, 1)
+	return c.CallContext(context.Background(), command, params)
+}
+
+// CallContext is like Call, but it stops waiting for the response once ctx is
+// done. The default timeout applies only if ctx has no deadline.
+func (c *Conn) CallContext(ctx context.Context, command string, params map[string]any) (map[string]any, error) {
+	ctx, cancel := c.withDefaultTimeout(ctx)
+	defer cancel()
+
+	s, err := c.session()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.call(ctx, s, command, params)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := checkResponse(command, data); err != nil {
+		return nil, err
+	}
+
+	var resp map[string]any
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("malformed response to %s: %w", command, err)
+	}
+	return resp, nil
+}
+
+func (c *Conn) session() (*session, error) {
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sess == nil {
+		return nil, &DisconnectedError{Err: errReconnecting}
+	}
+	return c.sess, nil
+}
+
+func (c *Conn) withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.opts.timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.opts.timeout)
+}
+
+func (c *Conn) call(ctx context.Context, s *session, command string, params map[string]any) ([]byte, error) {
+	resCh := make(chan []byte, 1)
 
 	c.mu.Lock()
 	id := c.nextID
 	c.nextID++
 	c.handlers[id] = resCh
+	if command == "start_listening" {
+		s.listenID = id
+	}
 	c.mu.Unlock()
 
+	// Removing the handler makes runRead drop the late response, if any
 	defer func() {
 		c.mu.Lock()
 		delete(c.handlers, id)
 		c.mu.Unlock()
 	}()
 
-	c.reqs <- request{id: id, command: command, params: params}
+	select {
+	case s.reqs <- request{id: id, command: command, params: params}:
+	case <-s.done:
+		return nil, c.sessionError(s)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to send %s: %w", command, ctx.Err())
+	}
 
 	select {
 	case res := <-resCh:
 		return res, nil
-	case <-time.After(10 * time.Second):
-		return nil, errors.New("timed out waiting for response")
+	case <-s.done:
+		return nil, c.sessionError(s)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to wait for response to %s: %w", command, ctx.Err())
+	}
+}
+
+// sessionError explains why session s, which is done, has failed
+func (c *Conn) sessionError(s *session) error {
+	if err := c.Err(); err != nil {
+		return err
 	}
+	return &DisconnectedError{Err: s.err}
 }
@@ -0,0 +1,345 @@
+package gozo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dottedmag/gozo"
+	"github.com/dottedmag/gozo/gozotest"
+)
+
+var switchValue = gozo.ValueID{CommandClass: 0x25, Property: "currentValue"}
+
+func newConn(t *testing.T, srv *gozotest.Server, opts ...gozo.Option) *gozo.Conn {
+	t.Helper()
+
+	opts = append([]gozo.Option{gozo.WithBackoff(10*time.Millisecond, 10*time.Millisecond)}, opts...)
+	c, err := gozo.NewConn(srv.URL, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCommands(t *testing.T) {
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2, Name: "lamp", Status: gozo.NodeStatusAlive})
+	srv.SetValue(2, switchValue, false)
+	c := newConn(t, srv)
+	ctx := context.Background()
+
+	state, err := c.NodeGetState(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Name != "lamp" || state.Status != gozo.NodeStatusAlive {
+		t.Errorf("unexpected node state %+v", state)
+	}
+
+	if _, err := c.EndpointInvokeCCAPI(ctx, 2, 0, 0x25, "set", true); err != nil {
+		t.Fatal(err)
+	}
+	v, err := c.NodeGetValue(ctx, 2, switchValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != true {
+		t.Errorf("value is %#v, want true", v)
+	}
+
+	if _, err := c.NodeGetState(ctx, 3); !gozo.IsNodeNotFound(err) {
+		t.Errorf("NodeGetState of missing node returned %v, want node_not_found", err)
+	}
+	if _, err := c.Call("no.such_command", nil); !gozo.IsUnknownCommand(err) {
+		t.Errorf("Call of unknown command returned %v, want unknown_command", err)
+	}
+
+	srv.SetNodeStatus(2, gozo.NodeStatusDead)
+	err = c.NodeSetValue(ctx, 2, switchValue, false)
+	var se *gozo.ServerError
+	if !errors.As(err, &se) || !gozo.IsNodeDead(err) {
+		t.Errorf("NodeSetValue on dead node returned %v, want *ServerError of dead node", err)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	srv := gozotest.NewServer(t)
+	block := make(chan struct{})
+	srv.Handle("slow", func(map[string]any) (any, error) {
+		<-block
+		return nil, nil
+	})
+	defer close(block)
+	c := newConn(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.CallContext(ctx, "slow", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CallContext returned %v, want deadline exceeded", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	srv := gozotest.NewServer(t,
+		gozo.NodeState{NodeID: 2, Status: gozo.NodeStatusAlive},
+		gozo.NodeState{NodeID: 3, Status: gozo.NodeStatusAlive})
+	c := newConn(t, srv)
+
+	events, unsubscribe := c.Subscribe(gozo.EventFilter{
+		Source:  gozo.SourceNode,
+		Events:  []string{gozo.EventValueUpdated, gozo.EventDead},
+		NodeIDs: []int{3},
+	})
+
+	srv.UpdateValue(2, switchValue, true)
+	srv.SetNodeStatus(3, gozo.NodeStatusAsleep)
+	srv.UpdateValue(3, switchValue, true)
+	srv.SetNodeStatus(3, gozo.NodeStatusDead)
+	srv.SendEvent(map[string]any{"source": "node", "event": "something new", "nodeId": 3})
+
+	ev := <-events
+	vu, ok := ev.(*gozo.ValueUpdatedEvent)
+	if !ok || vu.NodeID != 3 || vu.ValueID.CommandClass != 0x25 || vu.NewValue != true {
+		t.Errorf("unexpected event %#v", ev)
+	}
+	ev = <-events
+	if _, ok := ev.(*gozo.NodeDeadEvent); !ok || ev.Header().NodeID != 3 {
+		t.Errorf("unexpected event %#v", ev)
+	}
+
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Errorf("channel is not closed after unsubscribing")
+	}
+}
+
+func TestUnknownMessage(t *testing.T) {
+	srv := gozotest.NewServer(t)
+	unknown := make(chan []byte, 1)
+	c := newConn(t, srv, gozo.WithUnknownMessageHandler(func(data []byte, err error) {
+		unknown <- data
+	}))
+
+	srv.Send(map[string]any{"type": "something new"})
+	if data := <-unknown; string(data) != `{"type":"something new"}`+"\n" {
+		t.Errorf("unexpected unknown message %q", data)
+	}
+
+	if _, err := c.ControllerGetState(context.Background()); err != nil {
+		t.Errorf("connection is not usable after unknown message: %v", err)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	srv := gozotest.NewServer(t)
+	srv.SetSchemaVersions(0, gozo.MaxSchemaVersion+10)
+	c := newConn(t, srv)
+	if c.SchemaVersion() != gozo.MaxSchemaVersion {
+		t.Errorf("negotiated schema version %d, want %d", c.SchemaVersion(), gozo.MaxSchemaVersion)
+	}
+
+	srv.SetSchemaVersions(gozo.MaxSchemaVersion+1, gozo.MaxSchemaVersion+10)
+	_, err := gozo.NewConn(srv.URL, nil)
+	var schemaErr *gozo.SchemaVersionError
+	if !errors.As(err, &schemaErr) {
+		t.Errorf("NewConn returned %v, want *SchemaVersionError", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2})
+	block := make(chan struct{})
+	srv.Handle("slow", func(map[string]any) (any, error) {
+		<-block
+		return nil, nil
+	})
+	defer close(block)
+
+	reconnected := make(chan struct{}, 1)
+	c := newConn(t, srv, gozo.WithReconnectHandler(func() {
+		reconnected <- struct{}{}
+	}))
+
+	errCh := make(chan error)
+	go func() {
+		_, err := c.Call("slow", nil)
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	srv.DropConnections()
+
+	var de *gozo.DisconnectedError
+	if err := <-errCh; !errors.As(err, &de) {
+		t.Errorf("in-flight call returned %v, want *DisconnectedError", err)
+	}
+
+	<-reconnected
+	if _, err := c.NodeGetState(context.Background(), 2); err != nil {
+		t.Errorf("call after reconnection failed: %v", err)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2})
+
+	// Server that accepts the connection, but does not greet
+	srv.Stall()
+	if _, err := gozo.NewConn(srv.URL, nil, gozo.WithKeepalive(50*time.Millisecond)); err == nil {
+		t.Errorf("NewConn to silent server succeeded")
+	}
+	srv.Resume()
+
+	reconnected := make(chan struct{}, 1)
+	c := newConn(t, srv, gozo.WithKeepalive(50*time.Millisecond), gozo.WithReconnectHandler(func() {
+		reconnected <- struct{}{}
+	}))
+
+	// Server that stops answering pings is noticed, and is reconnected to
+	// once it is back
+	srv.Stall()
+	time.Sleep(200 * time.Millisecond)
+	var de *gozo.DisconnectedError
+	if _, err := c.NodeGetState(context.Background(), 2); !errors.As(err, &de) {
+		t.Errorf("call to silent server returned %v, want *DisconnectedError", err)
+	}
+
+	srv.Resume()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no reconnection after server is back")
+	}
+	if _, err := c.NodeGetState(context.Background(), 2); err != nil {
+		t.Errorf("call after reconnection failed: %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	srv := gozotest.NewServer(t)
+	block := make(chan struct{})
+	srv.Handle("slow", func(map[string]any) (any, error) {
+		<-block
+		return nil, nil
+	})
+	defer close(block)
+	c := newConn(t, srv)
+	events, _ := c.Subscribe(gozo.EventFilter{})
+
+	errCh := make(chan error)
+	go func() {
+		_, err := c.Call("slow", nil)
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-errCh; !errors.Is(err, gozo.ErrClosed) {
+		t.Errorf("pending call returned %v, want ErrClosed", err)
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Errorf("Done is not closed after Close")
+	}
+	if !errors.Is(c.Err(), gozo.ErrClosed) {
+		t.Errorf("Err returned %v, want ErrClosed", c.Err())
+	}
+	if _, ok := <-events; ok {
+		t.Errorf("subscription channel is not closed after Close")
+	}
+	if _, err := c.Call("slow", nil); !errors.Is(err, gozo.ErrClosed) {
+		t.Errorf("call after Close returned %v, want ErrClosed", err)
+	}
+}
+
+func TestModel(t *testing.T) {
+	srv := gozotest.NewServer(t, gozo.NodeState{NodeID: 2, Name: "lamp", Status: gozo.NodeStatusAlive})
+	srv.SetValue(2, switchValue, false)
+	c := newConn(t, srv)
+
+	if v, ok := c.Value(2, switchValue); !ok || v != false {
+		t.Errorf("seeded value is %#v, %v, want false", v, ok)
+	}
+
+	events, _ := c.Subscribe(gozo.EventFilter{})
+
+	srv.UpdateValue(2, switchValue, true)
+	<-events
+	if v, _ := c.Value(2, switchValue); v != true {
+		t.Errorf("updated value is %#v, want true", v)
+	}
+
+	srv.SendEvent(map[string]any{"source": "node", "event": "metadata updated", "nodeId": 2, "args": map[string]any{
+		"commandClass": 0x25, "endpoint": 0, "property": "currentValue",
+		"metadata": map[string]any{"type": "boolean", "label": "Current value"},
+	}})
+	<-events
+	if vals := c.Values(2); len(vals) != 1 || vals[0].Metadata.Label != "Current value" {
+		t.Errorf("values after metadata update are %+v", vals)
+	}
+
+	srv.AddNode(gozo.NodeState{NodeID: 3, Name: "fan"})
+	<-events
+	if n, ok := c.Node(3); !ok || n.Name != "fan" {
+		t.Errorf("added node is %+v, %v", n, ok)
+	}
+
+	srv.SetNodeStatus(2, gozo.NodeStatusDead)
+	<-events
+	if n, _ := c.Node(2); n.Status != gozo.NodeStatusDead {
+		t.Errorf("status of node 2 is %v, want dead", n.Status)
+	}
+
+	srv.RemoveNode(3)
+	<-events
+	if st := c.DriverState(); len(st.Nodes) != 1 || st.Nodes[0].NodeID != 2 {
+		t.Errorf("nodes after removal are %+v", st.Nodes)
+	}
+}
+
+func TestResolveNode(t *testing.T) {
+	srv := gozotest.NewServer(t,
+		gozo.NodeState{NodeID: 2, Name: "lamp", Location: "kitchen"},
+		gozo.NodeState{NodeID: 3, Name: "lamp", Location: "hall"},
+		gozo.NodeState{NodeID: 4, Name: "fan", Location: "hall"})
+	c := newConn(t, srv)
+
+	tests := []struct {
+		ref string
+		id  int
+		err bool
+	}{
+		{ref: "7", id: 7},
+		{ref: "fan", id: 4},
+		{ref: "hall/fan", id: 4},
+		{ref: "kitchen/lamp", id: 2},
+		{ref: "lamp", err: true},
+		{ref: "kitchen/fan", err: true},
+		{ref: "heater", err: true},
+	}
+	for _, tt := range tests {
+		id, err := c.ResolveNode(tt.ref)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ResolveNode(%q) = %d, want error", tt.ref, id)
+			}
+			continue
+		}
+		if err != nil || id != tt.id {
+			t.Errorf("ResolveNode(%q) = %d, %v, want %d", tt.ref, id, err, tt.id)
+		}
+	}
+
+	events, _ := c.Subscribe(gozo.EventFilter{})
+	srv.AddNode(gozo.NodeState{NodeID: 5, Name: "heater", Location: "hall"})
+	<-events
+	if id, err := c.ResolveNode("hall/heater"); err != nil || id != 5 {
+		t.Errorf("ResolveNode of added node = %d, %v, want 5", id, err)
+	}
+}